@@ -9,62 +9,487 @@
  *
  *  <mac>,<switch>/<module>.<port>,<vlan>,<interface>,<class>
  *
+ *	and with -extended two more columns follow
+ *
+ *  <mac>,<switch>/<module>.<port>,<vlan>,<interface>,<class>,<source>,<trunk>
+ *
+ *	The hosts are found through LLDP, and for hosts that do not run it,
+ *	through the addresses the switch learned on its edge ports. The source
+ *	is lldp or fdb accordingly, fdb lines have no interface. LLDP neighbors
+ *	that give no mac address are reported on stderr and left out. Ports
+ *	that carry tagged vlans, are listed in -uplinks or have learned more
+ *	than -max-macs addresses are uplinks, the addresses learned on them are
+ *	not hosts.
+ *
+ *	The vlan is the pvid of the port, or its untagged vlan, or for ports
+ *	that have neither the -control-vlan. The trunk is the ; separated vlans
+ *	the port carries tagged, empty for access ports. The module comes from
+ *	ENTITY-MIB or else the interface name, 0 when neither says.
+ *
+ *	Several switches may be given, as arguments, in a -switches file of one
+ *	per line or with -all as every switch of the profiles file. They are
+ *	queried -workers at a time and the lines of each are written together.
+ *	A switch that fails is reported on stderr and the others carry on, the
+ *	exit status is 1 if any failed.
+ *
  *	usage:
- *		switchmac <switch-address> [experimental|control]
+ *		switchmac [-profiles file] [-driver name] [-uplinks ports] [-max-macs n]
+ *		          [-control-vlan vid] [-workers n] [-extended] [snmp options]
+ *		          {<switch-address>... | -switches file | -all} [experimental|control]
  *
  *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/hex"
+	"flag"
 	"fmt"
 	dsnmp "github.com/deter-project/switch-drivers/snmp/snmp"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
-const CONTROL_VLAN int = 2003
+// the vlan of ports that have none of their own, unless -control-vlan says
+const defaultControlVlan = 2003
 
 func main() {
 
 	//no timestamp on logging
 	log.SetFlags(0)
 
-	//grab the hostname from args
-	if len(os.Args) < 3 {
+	driver := flag.String("driver", "",
+		"switch driver to use (default from profile or "+dsnmp.DefaultDriver+")")
+	profiles := flag.String("profiles", "",
+		"switch profiles file (default $"+dsnmp.ProfilesEnv+" or "+
+			dsnmp.DefaultProfilesPath+")")
+	uplinks := flag.String("uplinks", "",
+		"ports to leave out of the fdb entries, a list such as 49-52,swp60")
+	maxMacs := flag.Int("max-macs", 16,
+		"take ports with more learned addresses to be uplinks, 0 for no limit")
+	controlVlan := flag.Int("control-vlan", defaultControlVlan,
+		"vlan reported for ports with neither a pvid nor an untagged vlan")
+	switches := flag.String("switches", "",
+		"file listing the switches to query, one per line")
+	all := flag.Bool("all", false,
+		"query every switch in the profiles file")
+	workers := flag.Int("workers", 8,
+		"number of switches queried at once")
+	extended := flag.Bool("extended", false,
+		"append the source and trunk columns to each line")
+	dsnmp.DefaultOptions().BindFlags(flag.CommandLine)
+	flag.Usage = func() {
+		log.Print(usage())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	//the class is the last argument, the switches come before it
+	if flag.NArg() < 1 || *workers < 1 {
 		log.Fatal(usage())
 	}
-	host := os.Args[1]
-	class := os.Args[2]
+	args := flag.Args()
+	class := args[len(args)-1]
+	hosts := args[:len(args)-1]
 
-	//create a new instance of the switch controller
-	s, err := dsnmp.NewSwitchControllerSnmp(host)
+	pf, err := dsnmp.LoadProfiles(*profiles)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer s.Snmp.Conn.Close()
+	if *switches != "" {
+		listed, err := readSwitches(*switches)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hosts = append(hosts, listed...)
+	}
+	if *all {
+		hosts = append(hosts, pf.Names()...)
+	}
+	if len(hosts) == 0 {
+		log.Fatal(usage())
+	}
+
+	q := &query{
+		profiles:    pf,
+		driver:      *driver,
+		class:       class,
+		uplinks:     *uplinks,
+		maxMacs:     *maxMacs,
+		controlVlan: *controlVlan,
+		extended:    *extended,
+	}
+
+	//query the switches a few at a time, the lines of a switch are written
+	//together as it finishes
+	type result struct {
+		host  string
+		lines []string
+		err   error
+	}
+	work := make(chan string)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < *workers && i < len(hosts); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range work {
+				lines, err := q.run(host)
+				results <- result{host, lines, err}
+			}
+		}()
+	}
+	go func() {
+		for _, host := range hosts {
+			work <- host
+		}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	failed := false
+	for r := range results {
+		if r.err != nil {
+			log.Printf("%s: %v", r.host, r.err)
+			failed = true
+			continue
+		}
+		for _, line := range r.lines {
+			fmt.Println(line)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+
+}
+
+// A query is what to ask of each switch.
+type query struct {
+	profiles    *dsnmp.Profiles
+	driver      string
+	class       string
+	uplinks     string
+	maxMacs     int
+	controlVlan int
+	extended    bool
+}
+
+// run queries the switch host and returns its lines in the expected format.
+func (q *query) run(host string) ([]string, error) {
+
+	//create a new instance of the switch controller
+	s, err := connect(q.profiles, host, q.driver)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	//the port and interface tables are read once for all that follows
+	pm, err := s.GetPortMap()
+	if err != nil {
+		return nil, err
+	}
+	ifxs, err := s.GetInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	pc, ok := s.(portMapController)
+	if !ok {
+		pc = rereading{s}
+	}
 
 	//ask the switch who it's neighbors are
-	nbrs, err := s.GetNeighbors()
+	nbrs, err := pc.GetNeighborsWith(pm)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	//plop out the expected format
-	for _, n := range nbrs {
-		fmt.Printf("%s,%s/%d.%d,%d,%s:%s,%s\n",
+	//the vlans and modules of the ports
+	ports, err := getPortVlans(s, ifxs)
+	if err != nil {
+		return nil, err
+	}
+	modules, err := pc.GetModulesWith(pm)
+	if err != nil {
+		return nil, err
+	}
+
+	//the hosts that do not run lldp are in the forwarding database
+	fdb, err := edgeFdb(pc, pm, nbrs, ports, q.uplinks, q.maxMacs)
+	if err != nil {
+		return nil, err
+	}
+
+	//plop out the expected format, a line for every neighbor of every port
+	var lines []string
+	for _, n := range dsnmp.SortNeighbors(nbrs) {
+		//neighbors whose chassis and port ids are not mac addresses give no
+		//host to report
+		if len(n.RemoteMac) == 0 {
+			log.Printf("%s: no mac address for neighbor %s on port %d, skipped",
+				host, n.RemoteName, n.BridgeIfIndex)
+			continue
+		}
+		p := ports[n.BridgeIfIndex]
+		line := fmt.Sprintf("%s,%s/%d.%d,%d,%s:%s,%s",
 			hex.EncodeToString(n.RemoteMac),
 			host,
-			0,
+			modules[n.LocalIfIndex],
 			n.BridgeIfIndex,
-			CONTROL_VLAN,
+			p.vlan(q.controlVlan),
 			n.RemoteName, n.RemotePortName,
-			class,
+			q.class,
 		)
+		lines = append(lines, q.extend(line, "lldp", p))
+	}
+	for _, e := range fdb {
+		p := ports[e.BridgePort]
+		vlan := e.Vlan
+		if vlan == 0 {
+			vlan = p.vlan(q.controlVlan)
+		}
+		line := fmt.Sprintf("%s,%s/%d.%d,%d,,%s",
+			hex.EncodeToString(e.Mac),
+			host,
+			modules[e.IfIndex],
+			e.BridgePort,
+			vlan,
+			q.class,
+		)
+		lines = append(lines, q.extend(line, "fdb", p))
+	}
+	return lines, nil
+
+}
+
+// extend appends the source and trunk columns to a line when -extended asks
+// for them, by default the lines keep the five columns the web interface
+// expects.
+func (q *query) extend(line, source string, p *portVlan) string {
+
+	if !q.extended {
+		return line
+	}
+	return fmt.Sprintf("%s,%s,%s", line, source, p.trunk())
+
+}
+
+// readSwitches reads a file of switches, one per line. Blank lines and lines
+// starting with # are skipped.
+func readSwitches(path string) ([]string, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return hosts, nil
+
+}
+
+// the vlans of a bridge port
+type portVlan struct {
+	pvid             int
+	untagged, tagged []int
+}
+
+// getPortVlans returns the vlans of every bridge port in ifxs keyed by bridge
+// port.
+func getPortVlans(
+	s dsnmp.SwitchController, ifxs []dsnmp.Interface) (map[int]*portVlan, error) {
+
+	vlans, err := s.GetVlans()
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make(map[int]*portVlan)
+	for _, i := range ifxs {
+		if i.BridgeIndex != 0 {
+			ports[i.BridgeIndex] = &portVlan{pvid: i.Pvid}
+		}
+	}
+	for _, v := range vlans {
+		for bridge, p := range ports {
+			switch {
+			case dsnmp.IsPortSet(bridge-1, v.AccessPorts):
+				p.untagged = append(p.untagged, v.Index)
+			case dsnmp.IsPortSet(bridge-1, v.EgressPorts):
+				p.tagged = append(p.tagged, v.Index)
+			}
+		}
+	}
+	return ports, nil
+
+}
+
+// vlan returns the vlan untagged frames of the port are in, the pvid or the
+// untagged vlan, or control if the port has neither.
+func (p *portVlan) vlan(control int) int {
+
+	switch {
+	case p == nil:
+		return control
+	case p.pvid != 0:
+		return p.pvid
+	case len(p.untagged) > 0:
+		return p.untagged[0]
+	}
+	return control
+
+}
+
+// trunk returns the tagged vlans of the port separated by ;
+func (p *portVlan) trunk() string {
+
+	if p == nil {
+		return ""
+	}
+	vids := make([]string, len(p.tagged))
+	for i, vid := range p.tagged {
+		vids[i] = strconv.Itoa(vid)
+	}
+	return strings.Join(vids, ";")
+
+}
+
+// edgeFdb returns the addresses learned on the edge ports of the switch that
+// are not those of an LLDP neighbor. Ports carrying tagged vlans, the ports
+// in uplinks and ports with more than maxMacs learned addresses are not edge
+// ports.
+func edgeFdb(
+	s portMapController,
+	pm *dsnmp.PortMap,
+	nbrs map[dsnmp.NeighborKey]*dsnmp.Neighbor,
+	ports map[int]*portVlan,
+	uplinks string,
+	maxMacs int) ([]dsnmp.FdbEntry, error) {
+
+	fdb, err := s.GetFdbWith(pm)
+	if err != nil {
+		return nil, err
 	}
 
+	excluded := make(map[int]bool)
+	if uplinks != "" {
+		up, err := pm.ResolvePortList(uplinks)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range up {
+			excluded[p] = true
+		}
+	}
+	for bridge, p := range ports {
+		if len(p.tagged) > 0 {
+			excluded[bridge] = true
+		}
+	}
+
+	learned := make(map[int]int)
+	for _, e := range fdb {
+		if e.Status == dsnmp.FdbLearned {
+			learned[e.BridgePort]++
+		}
+	}
+
+	var result []dsnmp.FdbEntry
+	for _, e := range fdb {
+		if e.Status != dsnmp.FdbLearned || excluded[e.BridgePort] {
+			continue
+		}
+		if maxMacs > 0 && learned[e.BridgePort] > maxMacs {
+			continue
+		}
+		if e.BridgePort == 0 || isNeighbor(nbrs, e.Mac) {
+			continue
+		}
+		result = append(result, e)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].BridgePort < result[j].BridgePort
+	})
+	return result, nil
+
+}
+
+// isNeighbor returns whether mac is the address of an LLDP neighbor.
+func isNeighbor(nbrs map[dsnmp.NeighborKey]*dsnmp.Neighbor, mac []byte) bool {
+
+	for _, n := range nbrs {
+		if bytes.Equal(n.RemoteMac, mac) {
+			return true
+		}
+	}
+	return false
+
+}
+
+// A portMapController reads the tables that depend on the ports of a switch
+// with a PortMap already read, as the snmp driver does, so the port tables
+// are read once for all of them.
+type portMapController interface {
+	GetNeighborsWith(ports *dsnmp.PortMap) (map[dsnmp.NeighborKey]*dsnmp.Neighbor, error)
+	GetFdbWith(ports *dsnmp.PortMap) ([]dsnmp.FdbEntry, error)
+	GetModulesWith(ports *dsnmp.PortMap) (map[int]int, error)
+}
+
+// rereading is the portMapController of drivers that read the port tables
+// themselves each time.
+type rereading struct {
+	dsnmp.SwitchController
+}
+
+func (r rereading) GetNeighborsWith(
+	*dsnmp.PortMap) (map[dsnmp.NeighborKey]*dsnmp.Neighbor, error) {
+	return r.GetNeighbors()
+}
+
+func (r rereading) GetFdbWith(*dsnmp.PortMap) ([]dsnmp.FdbEntry, error) {
+	return r.GetFdb()
+}
+
+func (r rereading) GetModulesWith(*dsnmp.PortMap) (map[int]int, error) {
+	return r.GetModules()
+}
+
+// connect resolves host through the profiles file, with explicitly set
+// connection flags taking precedence, and creates a switch controller for it.
+func connect(
+	pf *dsnmp.Profiles, host, driver string) (dsnmp.SwitchController, error) {
+
+	address, d, opts, err := pf.ResolveFlags(host, driver, flag.CommandLine)
+	if err != nil {
+		return nil, err
+	}
+
+	return dsnmp.NewSwitchController(d, address, opts)
+
 }
 
 func usage() string {
-	return "usage:\n  switchmac <switch-address> [experimental|control]"
+	return "usage:\n  switchmac [-profiles file] [-driver name] [-uplinks ports] [-max-macs n]\n" +
+		"            [-control-vlan vid] [-workers n] [-extended] [snmp options]\n" +
+		"            {<switch-address>... | -switches file | -all} [experimental|control]"
 }
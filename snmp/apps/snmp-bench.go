@@ -0,0 +1,205 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter snmp-bench application
+ * ============================
+ *
+ *	This application times the switch controller read paths against a
+ *	recorded agent, so changes to how tables are fetched can be measured
+ *	without a switch. Every request to the recording is delayed by -latency
+ *	to model a slow management network, the number of requests each
+ *	operation takes is reported along with the time.
+ *
+ *	usage:
+ *		snmp-bench [-n runs] [-latency d] [-max-varbinds n] [-sweep] [snmp options] <recording>
+ *		snmp-bench -record [-profiles file] [snmp options] <switch-address> <recording>
+ *
+ *	examples:
+ *		snmp-bench -record 10.47.1.5 leaf1.walk
+ *		snmp-bench -latency 20ms -max-repetitions 50 -concurrency 2 leaf1.walk
+ *		snmp-bench -sweep leaf1.walk
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/deter-project/switch-drivers/snmp/internal/snmptest"
+	dsnmp "github.com/deter-project/switch-drivers/snmp/snmp"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// the operations that are timed
+var operations = []struct {
+	name string
+	run  func(c *dsnmp.SwitchControllerSnmp) error
+}{
+	{"interfaces", func(c *dsnmp.SwitchControllerSnmp) error {
+		_, err := c.GetInterfaces()
+		return err
+	}},
+	{"vlans", func(c *dsnmp.SwitchControllerSnmp) error {
+		_, err := c.GetVlans()
+		return err
+	}},
+	{"neighbors", func(c *dsnmp.SwitchControllerSnmp) error {
+		_, err := c.GetNeighbors()
+		return err
+	}},
+	// the read every Set method does before it writes
+	{"vlan-table", func(c *dsnmp.SwitchControllerSnmp) error {
+		_, err := c.Begin().Commit()
+		return err
+	}},
+}
+
+func main() {
+
+	//no timestamp on logging
+	log.SetFlags(0)
+
+	runs := flag.Int("n", 5, "number of runs of each operation")
+	latency := flag.Duration("latency", 10*time.Millisecond,
+		"delay added to every request to the recording")
+	varbinds := flag.Int("max-varbinds", 0,
+		"limit the varbinds in a response of the recording, 0 for no limit")
+	sweep := flag.Bool("sweep", false,
+		"run over a range of max-repetitions and concurrency settings")
+	record := flag.Bool("record", false,
+		"record a switch instead of running the benchmark")
+	profiles := flag.String("profiles", "",
+		"switch profiles file (default $"+dsnmp.ProfilesEnv+" or "+
+			dsnmp.DefaultProfilesPath+")")
+	opts := dsnmp.DefaultOptions()
+	opts.BindFlags(flag.CommandLine)
+	flag.Usage = func() {
+		log.Print(usage())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *record {
+		if flag.NArg() != 2 {
+			log.Fatal(usage())
+		}
+		err := recordSwitch(flag.Arg(0), flag.Arg(1), *profiles)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.NArg() != 1 || *runs < 1 {
+		log.Fatal(usage())
+	}
+	rec, err := snmptest.LoadRecording(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	rec.Latency = *latency
+	rec.MaxVarbinds = *varbinds
+
+	configs := []dsnmp.Options{*opts}
+	if *sweep {
+		configs = nil
+		for _, n := range []int{1, 2, 4} {
+			for _, r := range []int{10, 25, 50, 100} {
+				o := *opts
+				o.Concurrency, o.MaxRepetitions = n, r
+				configs = append(configs, o)
+			}
+		}
+	}
+
+	fmt.Printf("%s, latency %v, max-varbinds %d, %d runs\n",
+		flag.Arg(0), *latency, *varbinds, *runs)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "operation\tmax-repetitions\tconcurrency\trequests/op\ttime/op")
+	for i := range configs {
+		o := &configs[i]
+		c := dsnmp.NewSwitchControllerAgent(rec, o)
+		for _, op := range operations {
+			requests, elapsed, err := bench(c, rec, op.run, *runs)
+			if err != nil {
+				log.Fatalf("%s: %v", op.name, err)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%v\n",
+				op.name,
+				setting(o.MaxRepetitions),
+				setting(o.Concurrency),
+				requests,
+				elapsed.Round(time.Microsecond),
+			)
+		}
+	}
+	w.Flush()
+
+}
+
+// bench runs op n times and returns the requests and time it took per run.
+func bench(
+	c *dsnmp.SwitchControllerSnmp,
+	rec *snmptest.Recording,
+	op func(*dsnmp.SwitchControllerSnmp) error,
+	n int) (int, time.Duration, error) {
+
+	requests := rec.Requests()
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		err := op(c)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	elapsed := time.Since(start)
+	return (rec.Requests() - requests) / n, elapsed / time.Duration(n), nil
+
+}
+
+// recordSwitch records the switch at host into the file at path.
+func recordSwitch(host, path, profiles string) error {
+
+	pf, err := dsnmp.LoadProfiles(profiles)
+	if err != nil {
+		return err
+	}
+	address, _, opts, err := pf.ResolveFlags(host, "", flag.CommandLine)
+	if err != nil {
+		return err
+	}
+
+	c, err := dsnmp.NewSwitchControllerSnmp(address, opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = c.Record(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+
+}
+
+func setting(x int) string {
+	if x == 0 {
+		return "default"
+	}
+	return fmt.Sprint(x)
+}
+
+func usage() string {
+	return "usage:\n" +
+		"  snmp-bench [-n runs] [-latency d] [-max-varbinds n] [-sweep]\n" +
+		"             [snmp options] <recording>\n" +
+		"  snmp-bench -record [-profiles file] [snmp options] <switch-address> <recording>"
+}
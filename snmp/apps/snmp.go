@@ -8,7 +8,14 @@
  * Controller Library to provide basic switch control. Here is a breif
  * synopsis
  *	usage:
- *		snmp host command
+ *		snmp [-profiles file] [-driver name] [-output format] [-v n] [snmp options] host command
+ *		snmp help [command]
+ *		snmp options:
+ *			-version 2c|3 -community c -port p -timeout d -retries n
+ *			-max-repetitions n -concurrency n -verify
+ *			-level noAuthNoPriv|authNoPriv|authPriv -user u
+ *			-auth MD5|SHA -auth-pass p -priv DES|AES -priv-pass p
+ *			-context name -context-engine hex -engine-id hex
  *		commands:
  *			show
  *			vlan list
@@ -22,16 +29,31 @@
  *
  *			vlan list
  *			interface list
+ *			fdb [vlan VIDS] [port PORTS]
  *
- *			vlan VID set trunk [PORT]
- *			vlan VID set access [PORT]
- *			vlan VID clear [PORT]
- *			vlan VID clear-all
+ *			vlan VIDS set trunk [native] [PORTS]
+ *			vlan VID set access [PORTS]
+ *			vlan VIDS clear [PORTS]
+ *			vlan VIDS clear-all
  *
- *			interface INTERFACE set trunk [VID]
- *			interface INTERFACE set access VID
- *			interface INTERFACE clear [VID]
- *			interface INTERFACE clear-all
+ *			interface PORTS set trunk [replace] [VIDS] [native VID]
+ *			interface PORTS set access VID   (moves them out of all other vlans)
+ *			interface PORTS clear [VIDS]
+ *			interface PORTS clear-all
+ *
+ *		a PORT is a bridge index, an interface name (swp12, Gi1/0/12), or
+ *		one of bridge:N, ifindex:N, lldp:N
+ *
+ *		PORTS and VIDS are lists of them with ranges, 1-8,17,33-40 or
+ *		swp1-swp16 and 100-199,300
+ *
+ *		-output json|yaml|csv|table selects how show, show-ports, vlan list,
+ *		interface list and fdb write what they read, table is the colored
+ *		text for people. Color is off when stdout is not a terminal.
+ *
+ *		-v 1 reports on stderr what is done, -v 2 also every snmp request.
+ *		The exit code is 0 on success, 1 when the switch could not be
+ *		reached or a command failed and 2 for a bad command line.
  *
  *----------------------------------------------------------
  *
@@ -41,20 +63,37 @@
  *			snmp 10.47.1.5 vlan delete 101
  *			snmp 10.47.1.5 vlan port 2 4 6 8 set access 47
  *			snmp 10.47.1.5 vlan port 1 3 5 7 set trunk 101 201 303
+ *			snmp 10.47.1.5 interface 7 set trunk 101 201 303 native 100
+ *			snmp 10.47.1.5 interface 7 set trunk replace 201 303 native 100
+ *			snmp 10.47.1.5 interface swp7 set access 47
+ *			snmp 10.47.1.5 vlan 47 set access swp2 swp4 ifindex:1006
+ *			snmp 10.47.1.5 vlan 47 set access 1-24
+ *			snmp 10.47.1.5 vlan create 100-199
+ *			snmp 10.47.1.5 interface swp49-swp52 set trunk 100-199 native 47
+ *			snmp 10.47.1.5 fdb vlan 47 port swp2
+ *			snmp -output json 10.47.1.5 show
  *
  *
  *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
 package main
 
 import (
+	"encoding/csv"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"flag"
 	dsnmp "github.com/deter-project/switch-drivers/snmp/snmp"
 	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"gopkg.in/yaml.v2"
 	"log"
+	"net"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Commonly used terminal colors
@@ -70,6 +109,180 @@ var redb = color.New(color.FgRed, color.Bold).SprintFunc()
 var yellow = color.New(color.FgYellow).SprintFunc()
 var bold = color.New(color.Bold).SprintFunc()
 
+// the output format of the read commands, see -output
+var outputFormat = "table"
+
+// how much to report on stderr, see -v
+var verbosity int
+
+// errors and verbose messages go to stderr, out of the way of the output
+var errlog = log.New(os.Stderr, "", 0)
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitFailure = 1 // the switch could not be reached or a command failed
+	exitUsage   = 2 // the command line is wrong
+)
+
+// A usageError is a command line a command cannot make sense of.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func usagef(format string, args ...interface{}) error {
+	return usageError(fmt.Sprintf(format, args...))
+}
+
+// A command is a top level command of the application. run is given the
+// arguments that follow the command name and returns a usageError for
+// arguments it cannot make sense of. help is wrapped by hand. synopsis and
+// format are functions as the colors are only settled once the flags are
+// parsed.
+type command struct {
+	name     string
+	synopsis func() []string
+	help     string
+	format   func() string
+	run      func(c dsnmp.SwitchController, args []string) error
+}
+
+// the commands in the order usage lists them
+var commands = []*command{
+	{
+		name: "show",
+		synopsis: func() []string {
+			return []string{blue("show")}
+		},
+		help: "Show the interfaces, vlans, port vlans and LLDP neighbors of the switch.",
+		format: func() string {
+			return "    " + blue("interfaces") + "\n" +
+				"      " + interfaceFormat() + "\n" +
+				"    " + blue("vlans") + "\n" +
+				"      " + vlanFormat() + "\n" +
+				"    " + blue("neighbors") + "\n" +
+				"      " + neighborFormat()
+		},
+		run: showSwitch,
+	},
+	{
+		name: "show-ports",
+		synopsis: func() []string {
+			return []string{blue("show-ports")}
+		},
+		help: "Show the vlans each bridge port is in.",
+		run:  showPorts,
+	},
+	{
+		name: "vlan",
+		synopsis: func() []string {
+			return []string{
+				blue("vlan list"),
+				fmt.Sprintf("%s %s",
+					blue("vlan {create | delete}"),
+					green("vids")),
+				fmt.Sprintf("%s %s %s %s",
+					blue("vlan"),
+					green("vids"),
+					blue("set {trunk [native] | access}"),
+					green("[ports]")),
+				fmt.Sprintf("%s %s %s %s",
+					blue("vlan"),
+					green("vids"),
+					blue("clear"),
+					green("[ports]")),
+				fmt.Sprintf("%s %s %s",
+					blue("vlan"),
+					green("vids"),
+					blue("clear-all")),
+			}
+		},
+		help: "List, create and delete vlans and set the ports in them. clear\n" +
+			"takes the ports out of the vlans, clear-all every port. A port is\n" +
+			"an access port of one vlan only, set access and set trunk native\n" +
+			"take a single vid.",
+		format: func() string {
+			return "      " + vlanFormat()
+		},
+		run: vlanCmd,
+	},
+	{
+		name: "interface",
+		synopsis: func() []string {
+			return []string{
+				blue("interface list"),
+				fmt.Sprintf("%s %s %s %s",
+					blue("interface"),
+					green("ports"),
+					blue("set trunk [replace]"),
+					green("[vids] [native vid]")),
+				fmt.Sprintf("%s %s %s %s",
+					blue("interface"),
+					green("ports"),
+					blue("set access"),
+					green("vid")),
+				fmt.Sprintf("%s %s %s %s",
+					blue("interface"),
+					green("ports"),
+					blue("clear"),
+					green("[vids]")),
+				fmt.Sprintf("%s %s %s",
+					blue("interface"),
+					green("ports"),
+					blue("clear-all")),
+			}
+		},
+		help: "List the interfaces and set the vlans of ports. set trunk adds\n" +
+			"the vlans to the ports, with replace they become the only ones and\n" +
+			"the pvid must be one of them unless a native vlan is given. set\n" +
+			"access moves the ports out of every other vlan.",
+		format: func() string {
+			return "      " + interfaceFormat()
+		},
+		run: interfaceCmd,
+	},
+	{
+		name: "fdb",
+		synopsis: func() []string {
+			return []string{
+				fmt.Sprintf("%s %s",
+					blue("fdb"),
+					green("[vlan vids] [port ports]")),
+			}
+		},
+		help: "List the mac addresses the switch has learned.",
+		format: func() string {
+			return "      " + fdbFormat()
+		},
+		run: fdbCmd,
+	},
+}
+
+// lookupCommand returns the command called name, nil if there is none.
+func lookupCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func isHelp(arg string) bool {
+	switch arg {
+	case "help", "-h", "-help", "--help":
+		return true
+	}
+	return false
+}
+
+// verbose reports what is being done on stderr, if -v is at least level.
+func verbose(level int, format string, args ...interface{}) {
+	if verbosity >= level {
+		errlog.Printf(format, args...)
+	}
+}
+
 // *** Entry point ***
 
 func main() {
@@ -77,305 +290,593 @@ func main() {
 	log.SetFlags(0)
 	log.SetOutput(os.Stdout)
 
-	// get the minimal set of arguments and initialize the switch controller
-	args := os.Args[1:]
+	driver := flag.String("driver", "",
+		"switch driver to use (default from profile or "+dsnmp.DefaultDriver+")")
+	profiles := flag.String("profiles", "",
+		"switch profiles file (default $"+dsnmp.ProfilesEnv+" or "+
+			dsnmp.DefaultProfilesPath+")")
+	flag.StringVar(&outputFormat, "output", "table",
+		"output format, one of json, yaml, csv or table")
+	flag.IntVar(&verbosity, "v", 0,
+		"verbosity, 1 reports what is done, 2 also every snmp request")
+	dsnmp.DefaultOptions().BindFlags(flag.CommandLine)
+	flag.Usage = func() {
+		errlog.Print(usage())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// color is for people at a terminal, not for programs reading the output
+	switch outputFormat {
+	case "table":
+		if !isatty.IsTerminal(os.Stdout.Fd()) {
+			color.NoColor = true
+		}
+	case "json", "yaml", "csv":
+		color.NoColor = true
+	default:
+		errlog.Printf("%s %s", red("unknown output format"), outputFormat)
+		errlog.Print(usage())
+		os.Exit(exitUsage)
+	}
+
+	// help needs no switch, snmp help [command] or snmp host command help
+	args := flag.Args()
+	if len(args) > 0 && isHelp(args[0]) {
+		os.Exit(help(args[1:]))
+	}
 	if len(args) < 2 {
-		log.Fatal(usage())
+		errlog.Print(usage())
+		os.Exit(exitUsage)
 	}
 	host := args[0]
-	command := args[1]
-	s, err := dsnmp.NewSwitchControllerSnmp(host)
+	cmd := lookupCommand(args[1])
+	if cmd == nil {
+		errlog.Printf("%s %s", red("unknown command"), args[1])
+		errlog.Print(usage())
+		os.Exit(exitUsage)
+	}
+	if len(args) > 2 && isHelp(args[2]) {
+		log.Print(commandHelp(cmd))
+		return
+	}
+
+	// initialize the switch controller and execute the command
+	s, err := connect(host, *profiles, *driver)
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer s.Snmp.Conn.Close()
-
-	// figure out the top level command and execute it
-	switch command {
-	case "show":
-		showSwitch(s)
-	case "show-ports":
-		showPorts(s)
-	case "interface":
-		interfaceCmd(s, args[2:])
-	case "vlan":
-		vlanCmd(s, args[2:])
+		errlog.Printf("%s %v", red("error:"), err)
+		os.Exit(exitFailure)
+	}
+	start := time.Now()
+	err = cmd.run(s, args[2:])
+	s.Close()
+	verbose(1, "%s took %v", cmd.name, time.Since(start).Round(time.Millisecond))
+
+	switch err.(type) {
+	case nil:
+		os.Exit(exitOK)
+	case usageError:
+		errlog.Printf("%s %v", red("invalid command:"), err)
+		errlog.Print(commandHelp(cmd))
+		os.Exit(exitUsage)
 	default:
-		log.Printf("%s %s", red("unknown command"), command)
-		log.Fatal(usage())
+		errlog.Printf("%s %v", red("error:"), err)
+		os.Exit(exitFailure)
 	}
 
 }
 
+// help shows the help of the command named in args, or the usage of the
+// application, and returns the exit code.
+func help(args []string) int {
+	if len(args) == 0 {
+		log.Print(usage())
+		flag.CommandLine.SetOutput(os.Stdout)
+		flag.PrintDefaults()
+		return exitOK
+	}
+	cmd := lookupCommand(args[0])
+	if cmd == nil {
+		errlog.Printf("%s %s", red("unknown command"), args[0])
+		errlog.Print(usage())
+		return exitUsage
+	}
+	log.Print(commandHelp(cmd))
+	return exitOK
+}
+
 //##
 // ### Interface Commands ~~~~~~~
 //##
-func interfaceCmd(c *dsnmp.SwitchControllerSnmp, args []string) {
-	if len(args) == 1 && args[0] == "list" {
-		listInterfaces(c)
-		return
+func interfaceCmd(c dsnmp.SwitchController, args []string) error {
+	if len(args) == 0 {
+		return usagef("interface needs list or a port")
+	}
+	if args[0] == "list" {
+		if len(args) != 1 {
+			return usagef("interface list takes no arguments")
+		}
+		return listInterfaces(c)
+	}
+	if len(args) < 2 {
+		return usagef("interface %s needs set, clear or clear-all", args[0])
 	}
 
-	bridge_index, err := strconv.Atoi(args[0])
+	ports, err := bridgePorts(c, args[:1])
 	if err != nil {
-		log.Printf("%s %s", red("invalid bridge index"), args[0])
-		log.Fatal(usage())
+		return err
 	}
 
-	if len(args) == 2 && args[1] == "clear-all" {
-		c.ClearPorts([]int{bridge_index})
-		return
+	switch args[1] {
+	case "clear-all":
+		if len(args) != 2 {
+			return usagef("clear-all takes no arguments")
+		}
+		verbose(1, "clearing bridge ports %v", ports)
+		return c.ClearPorts(ports)
+	case "set":
+		return interfaceSetCmd(c, ports, args[2:])
+	case "clear":
+		return interfaceClearCmd(c, ports, args[2:])
 	}
-	if len(args) >= 3 {
-		switch args[1] {
-		case "set":
-			interfaceSetCmd(c, bridge_index, args[2:])
-			return
-		case "clear":
-			interfaceClearCmd(c, bridge_index, args[2:])
-			return
+	return usagef("unknown interface command %s", args[1])
+}
+
+// bridgePorts resolves port arguments to bridge indices. Each argument is a
+// list of ports as dsnmp.ParsePortList reads them, 1-8,17 or swp1-swp16 for
+// example, of bridge indices or any of the names PortMap.Resolve accepts.
+// The port map is only read from the switch when a port is not given as a
+// bridge index.
+func bridgePorts(c dsnmp.SwitchController, args []string) ([]int, error) {
+	var ports []int
+	seen := make(map[int]bool)
+	for _, a := range args {
+		// a list that does not parse may still be a port name
+		names, err := dsnmp.ParsePortList(a)
+		if err != nil {
+			return resolvePorts(c, args)
 		}
+		for _, name := range names {
+			port, err := strconv.Atoi(name)
+			if err != nil {
+				return resolvePorts(c, args)
+			}
+			if port < 1 {
+				return nil, usagef("invalid port %s, bridge ports start at 1", name)
+			}
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports, nil
+}
+
+// resolvePorts resolves port arguments with the port map of the switch.
+func resolvePorts(c dsnmp.SwitchController, args []string) ([]int, error) {
+	pm, err := c.GetPortMap()
+	if err != nil {
+		return nil, err
+	}
+	ports, err := pm.ResolvePortList(args...)
+	if err != nil {
+		return nil, usagef("invalid port: %v", err)
 	}
-	log.Fatal(usage())
+	return ports, nil
 }
 
-func toInts(ss []string) []int {
-	vs := make([]int, len(ss))
-	for i, a := range ss {
-		v, err := strconv.Atoi(a)
+// toVids parses vid arguments, each a list of vids such as 100-199,300.
+func toVids(args []string) ([]int, error) {
+	var vids []int
+	for _, a := range args {
+		vs, err := dsnmp.ParseVids(a)
 		if err != nil {
-			log.Printf("%s %s", red("invalid integer value"), a)
-			log.Fatal(usage())
+			return nil, usagef("%v", err)
 		}
-		vs[i] = v
+		vids = append(vids, vs...)
+	}
+	return vids, nil
+}
+
+// toVid parses an argument that must be a single vid.
+func toVid(arg string) (int, error) {
+	vids, err := toVids([]string{arg})
+	if err != nil {
+		return 0, err
+	}
+	if len(vids) != 1 {
+		return 0, usagef("%s is not a single vid", arg)
 	}
-	return vs
+	return vids[0], nil
 }
 
-func interfaceSetCmd(c *dsnmp.SwitchControllerSnmp,
-	bridge_index int, args []string) {
+func interfaceSetCmd(c dsnmp.SwitchController,
+	ports []int, args []string) error {
 	if len(args) < 2 {
-		log.Fatal(usage())
+		return usagef("set needs trunk or access and a vid")
 	}
-	vids := toInts(args[1:])
 	switch args[0] {
 	case "trunk":
-		c.SetPortTrunk([]int{bridge_index}, vids)
+		// replace sets the trunk to exactly the given vlans
+		mode, trunk := dsnmp.TrunkAdd, args[1:]
+		if trunk[0] == "replace" {
+			mode, trunk = dsnmp.TrunkReplace, trunk[1:]
+		}
+		vids, native, err := trunkArgs(trunk)
+		if err != nil {
+			return err
+		}
+		verbose(1, "setting bridge ports %v trunk %v native %d", ports, vids, native)
+		return c.SetPortTrunk(ports, vids,
+			dsnmp.TrunkOptions{Native: native, Mode: mode})
 	case "access":
-		c.SetPortAccess([]int{bridge_index}, vids[0])
+		// an interface has exactly one access vlan, move it there
+		if len(args) != 2 {
+			return usagef("set access takes exactly one vid")
+		}
+		vid, err := toVid(args[1])
+		if err != nil {
+			return err
+		}
+		verbose(1, "moving bridge ports %v to vlan %d", ports, vid)
+		return c.MovePortAccess(ports, vid)
 	}
+	return usagef("unknown set command %s", args[0])
 }
 
-func interfaceClearCmd(c *dsnmp.SwitchControllerSnmp,
-	bridge_index int, args []string) {
-	vids := make([]int, len(args))
+// trunkArgs splits the arguments of a trunk command of the form
+// [VID...] [native VID] into the tagged vlans and the native vlan, which is 0
+// when not given.
+func trunkArgs(args []string) ([]int, int, error) {
 	for i, a := range args {
-		vid, err := strconv.Atoi(a)
+		if a != "native" {
+			continue
+		}
+		if i != len(args)-2 {
+			return nil, 0, usagef("native takes exactly one vid, last")
+		}
+		vids, err := toVids(args[:i])
+		if err != nil {
+			return nil, 0, err
+		}
+		native, err := toVid(args[i+1])
 		if err != nil {
-			log.Printf("%s %s", red("invalid vid"), a)
-			log.Fatal(usage())
+			return nil, 0, err
 		}
-		vids[i] = vid
+		return vids, native, nil
 	}
+	vids, err := toVids(args)
+	return vids, 0, err
+}
 
-	c.ClearPortVlans(bridge_index, vids)
+func interfaceClearCmd(c dsnmp.SwitchController,
+	ports []int, args []string) error {
+	vids, err := toVids(args)
+	if err != nil {
+		return err
+	}
+
+	for _, port := range ports {
+		verbose(1, "clearing bridge port %d from vlans %v", port, vids)
+		err := c.ClearPortVlans(port, vids)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 //##
 // ### Vlan Commands ~~~~~~~
 //##
-func vlanCmd(c *dsnmp.SwitchControllerSnmp, args []string) {
+func vlanCmd(c dsnmp.SwitchController, args []string) error {
 
 	if len(args) < 1 {
-		log.Fatal(usage())
+		return usagef("vlan needs list, create, delete or a vid")
 	}
 
-	getNum := func(i int) int {
-		if len(args) < i+1 {
-			log.Fatal(usage())
+	if args[0] == "list" {
+		if len(args) != 1 {
+			return usagef("vlan list takes no arguments")
 		}
-		number, err := strconv.Atoi(args[i])
-		if err != nil {
-			log.Printf("%s %s", red("invalid vlan number"), args[i])
-			log.Fatal(usage())
-			return -1
-		}
-		return number
+		return listVlans(c)
 	}
 
-	if len(args) == 1 && args[0] == "list" {
-		listVlans(c)
-		return
-	}
-
-	if len(args) == 2 {
-		if args[1] == "clear-all" {
-			c.ClearVlans([]int{getNum(0)})
-			return
+	switch args[0] {
+	case "create", "delete":
+		if len(args) < 2 {
+			return usagef("vlan %s needs a vid", args[0])
 		}
-		switch args[0] {
-		case "create":
-			number := getNum(1)
-			err := c.CreateVlan(number)
-			if err != nil {
-				log.Fatalf("%v", err)
+		vids, err := toVids(args[1:])
+		if err != nil {
+			return err
+		}
+		for _, vid := range vids {
+			if args[0] == "create" {
+				verbose(1, "creating vlan %d", vid)
+				err = c.CreateVlan(vid)
+			} else {
+				verbose(1, "deleting vlan %d", vid)
+				err = c.DeleteVlan(vid)
 			}
-			return
-		case "delete":
-			number := getNum(1)
-			err := c.DeleteVlan(number)
 			if err != nil {
-				log.Fatalf("%v", err)
+				return err
 			}
-			return
-		default:
-			log.Fatal(usage())
 		}
+		return nil
+	}
+
+	vids, err := toVids(args[:1])
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return usagef("vlan %s needs set, clear or clear-all", args[0])
 	}
 
-	vid := getNum(0)
 	switch args[1] {
 	case "set":
-		vlanSetCmd(c, vid, args[2:])
+		return vlanSetCmd(c, vids, args[2:])
 	case "clear":
-		vlanClearCmd(c, vid, args[2:])
+		return vlanClearCmd(c, vids, args[2:])
+	case "clear-all":
+		if len(args) != 2 {
+			return usagef("clear-all takes no arguments")
+		}
+		verbose(1, "clearing vlans %v", vids)
+		return c.ClearVlans(vids)
 	}
+	return usagef("unknown vlan command %s", args[1])
 
 }
 
-func vlanSetCmd(c *dsnmp.SwitchControllerSnmp, vid int, args []string) {
+func vlanSetCmd(c dsnmp.SwitchController, vids []int, args []string) error {
 
 	if len(args) < 2 {
-		log.Fatal(usage())
+		return usagef("set needs trunk or access and the ports")
 	}
-	switch args[0] {
+	native := false
+	kind, ports := args[0], args[1:]
+	if kind == "trunk" && ports[0] == "native" {
+		native, ports = true, ports[1:]
+	}
+	if len(ports) == 0 {
+		return usagef("set %s needs the ports", kind)
+	}
+
+	switch kind {
 	case "trunk":
-		interfaces := toInts(args[1:])
-		c.SetPortTrunk(interfaces, []int{vid})
 	case "access":
-		interfaces := toInts(args[1:])
-		c.SetPortAccess(interfaces, vid)
+		// a port is an access port of one vlan only
+		if len(vids) != 1 {
+			return usagef("set access takes a single vid")
+		}
+	default:
+		return usagef("unknown set command %s", kind)
+	}
+	if native && len(vids) != 1 {
+		return usagef("set trunk native takes a single vid")
+	}
+	interfaces, err := bridgePorts(c, ports)
+	if err != nil {
+		return err
 	}
 
+	verbose(1, "setting vlans %v %s on bridge ports %v", vids, kind, interfaces)
+	switch {
+	case native:
+		return c.SetPortTrunk(interfaces, nil, dsnmp.TrunkOptions{Native: vids[0]})
+	case kind == "trunk":
+		return c.SetPortTrunk(interfaces, vids, dsnmp.TrunkOptions{})
+	}
+	return c.SetPortAccess(interfaces, vids[0])
+
 }
 
-func vlanClearCmd(c *dsnmp.SwitchControllerSnmp, vid int, args []string) {
-	ports := make([]int, len(args))
-	for i, a := range args {
-		port, err := strconv.Atoi(a)
+func vlanClearCmd(c dsnmp.SwitchController, vids []int, args []string) error {
+	ports, err := bridgePorts(c, args)
+	if err != nil {
+		return err
+	}
+
+	for _, vid := range vids {
+		verbose(1, "clearing bridge ports %v from vlan %d", ports, vid)
+		err := c.ClearVlanPorts(vid, ports)
 		if err != nil {
-			log.Printf("%s %s", red("invalid port"), a)
-			log.Fatal(usage())
+			return err
 		}
-		ports[i] = port
 	}
+	return nil
+}
+
+//##
+// ### Forwarding Database Commands ~~~~~~~
+//##
+func fdbCmd(c dsnmp.SwitchController, args []string) error {
+
+	// the vlans and ports to show, all when nil
+	var vids, ports map[int]bool
+	for len(args) > 0 {
+		if len(args) < 2 {
+			return usagef("%s needs a value", args[0])
+		}
+		switch args[0] {
+		case "vlan":
+			vs, err := toVids(args[1:2])
+			if err != nil {
+				return err
+			}
+			vids = intSet(vids, vs)
+		case "port":
+			ps, err := bridgePorts(c, args[1:2])
+			if err != nil {
+				return err
+			}
+			ports = intSet(ports, ps)
+		default:
+			return usagef("unknown fdb filter %s", args[0])
+		}
+		args = args[2:]
+	}
+
+	fdb, err := c.GetFdb()
+	if err != nil {
+		return err
+	}
+
+	var entries []dsnmp.FdbEntry
+	for _, e := range fdb {
+		if vids != nil && !vids[e.Vlan] || ports != nil && !ports[e.BridgePort] {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].BridgePort != entries[j].BridgePort {
+			return entries[i].BridgePort < entries[j].BridgePort
+		}
+		return entries[i].Vlan < entries[j].Vlan
+	})
+	if structured() {
+		return emit(fdbRecords(entries))
+	}
+
+	var width int
+	for _, e := range entries {
+		maxMe(&width, len(e.IfName))
+	}
+	f := `%s %4d [%2d] %-` + strconv.Itoa(width) + `s %s`
+	for _, e := range entries {
+		log.Printf(f,
+			e.Mac,
+			e.Vlan,
+			e.BridgePort,
+			e.IfName,
+			showFdbStatus(e.Status),
+		)
+	}
+	return nil
+
+}
+
+// intSet adds xs to set, which is made if nil.
+func intSet(set map[int]bool, xs []int) map[int]bool {
+	if set == nil {
+		set = make(map[int]bool)
+	}
+	for _, x := range xs {
+		set[x] = true
+	}
+	return set
+}
+
+func showFdbStatus(s dsnmp.FdbStatus) string {
+	switch s {
+	case dsnmp.FdbLearned:
+		return green(s)
+	case dsnmp.FdbStatic, dsnmp.FdbSelf:
+		return cyan(s)
+	}
+	return yellow(s)
+}
+
+// connect resolves host through the profiles file, with explicitly set
+// connection flags taking precedence, and creates a switch controller for it.
+func connect(host, profiles, driver string) (dsnmp.SwitchController, error) {
+
+	pf, err := dsnmp.LoadProfiles(profiles)
+	if err != nil {
+		return nil, err
+	}
+	address, d, opts, err := pf.ResolveFlags(host, driver, flag.CommandLine)
+	if err != nil {
+		return nil, err
+	}
+	if verbosity >= 2 {
+		opts.Logger = errlog
+	}
+	verbose(1, "connecting to %s at %s:%d, %s driver, snmp v%s",
+		host, address, opts.Port, d, opts.Version)
+
+	return dsnmp.NewSwitchController(d, address, opts)
 
-	c.ClearVlanPorts(vid, ports)
 }
 
 // present information to the user on how to use this application
 func usage() string {
 
-	verbose := false
-
-	meta := fmt.Sprintf("%s %s", blue("snmp"), green("host command"))
-	show := fmt.Sprintf("%s", blue("show"))
-	showPorts := fmt.Sprintf("%s", blue("show-ports"))
-
-	vlanList := fmt.Sprintf("%s", blue("vlan list"))
-	interfaceList := fmt.Sprintf("%s", blue("interface list"))
-
-	vlanCreateDelete := fmt.Sprintf("%s %s",
-		blue("vlan {create | delete}"),
-		green("vid"))
-
-	vlanSet := fmt.Sprintf("%s %s %s %s",
-		blue("vlan"),
-		green("vid"),
-		blue("set {trunk | access}"),
-		green("[interface]"))
-
-	vlanClear := fmt.Sprintf("%s %s %s %s",
-		blue("vlan"),
-		green("vid"),
-		blue("clear"),
-		green("[interface]"))
-
-	vlanClearAll := fmt.Sprintf("%s %s %s",
-		blue("vlan"),
-		green("vid"),
-		blue("clear-all"))
-
-	interfaceSetTrunk := fmt.Sprintf("%s %s %s %s",
-		blue("interface"),
-		green("bridge-index"),
-		blue("set trunk"),
-		green("[vid]"))
-
-	interfaceSetAccess := fmt.Sprintf("%s %s %s %s",
-		blue("interface"),
-		green("bridge-index"),
-		blue("set access"),
-		green("vid"))
-
-	interfaceClear := fmt.Sprintf("%s %s %s %s",
-		blue("interface"),
-		green("bridge-index"),
-		blue("clear"),
-		green("[vid]"))
-
-	interfaceClearAll := fmt.Sprintf("%s %s %s",
-		blue("interface"),
-		green("bridge-index"),
-		blue("clear-all"))
-
-	ifFormat := fmt.Sprintf("%s(%s) '%s' %s %s %s",
+	meta := fmt.Sprintf("%s %s %s",
+		blue("snmp"),
+		green("[-profiles file] [-driver name] [-output json|yaml|csv|table] [-v n] [snmp options]"),
+		green("host command"))
+
+	text := redb("\nusage:\n") +
+		meta + "\n" +
+		"  " + bold("commands:") + " \n"
+	for i, c := range commands {
+		// commands of several lines are set apart
+		if i > 0 && (len(c.synopsis()) > 1 || len(commands[i-1].synopsis()) > 1) {
+			text += "\n"
+		}
+		for _, line := range c.synopsis() {
+			text += "    " + line + "\n"
+		}
+	}
+	text += "\n" +
+		"  " + bold("ports:") + " a bridge index, an interface name or one of " +
+		green("bridge:N ifindex:N lldp:N") + "\n" +
+		"  " + bold("lists:") + " ports and vids take lists and ranges, " +
+		green("1-8,17,33-40 swp1-swp16 100-199") + "\n" +
+		"  " + bold("help:") + " " + blue("snmp help") + " " + green("command") +
+		" or " + blue("snmp") + " " + green("host command") + " " + blue("help") + "\n\n"
+
+	return text
+}
+
+// present the help of a command
+func commandHelp(c *command) string {
+
+	text := redb("\nusage:\n")
+	for _, line := range c.synopsis() {
+		text += "  " + blue("snmp") + " " + green("[flags] host") + " " + line + "\n"
+	}
+	text += "\n  " + strings.Replace(c.help, "\n", "\n  ", -1) + "\n"
+	if c.format != nil {
+		text += "\n  " + bold("output format:") + "\n" + c.format() + "\n"
+	}
+	return text + "\n"
+}
+
+func interfaceFormat() string {
+	return fmt.Sprintf("%s(%s) '%s' %s %s %s %s",
 		bold("[bridge-index]"),
 		"device-index",
 		"label",
 		"type",
 		green("admin-status"),
 		yellow("op-status"),
+		"pvid:vid [tagged-only] [ingress-filter]",
 	)
+}
 
-	vlanFormat :=
-		"vid vlan-name\n" +
-			"      egress: [bridge-index list]\n" +
-			"      access: [bridge-index list]"
-
-	neighborFormat :=
-		"local-device-index <===> remote-host remote-device[mac] remote-uname"
-
-	outputFormat :=
-		"  " + bold("output format:") + "\n" +
-			"    " + blue("interfaces") + "\n" +
-			"      " + ifFormat + "\n" +
-			"    " + blue("vlans") + "\n" +
-			"      " + vlanFormat + "\n" +
-			"    " + blue("neighbors") + "\n" +
-			"      " + neighborFormat + "\n\n"
+func vlanFormat() string {
+	return "vid vlan-name\n" +
+		"      egress: [bridge-index list]\n" +
+		"      access: [bridge-index list]"
+}
 
-	text := redb("\nusage:\n") +
-		meta + "\n" +
-		"  " + bold("commands:") + " \n" +
-		"    " + show + "\n" +
-		"    " + showPorts + "\n\n" +
-		"    " + vlanList + "\n" +
-		"    " + vlanCreateDelete + "\n" +
-		"    " + vlanSet + "\n" +
-		"    " + vlanClear + "\n" +
-		"    " + vlanClearAll + "\n\n" +
-		"    " + interfaceList + "\n" +
-		"    " + interfaceSetTrunk + "\n" +
-		"    " + interfaceSetAccess + "\n" +
-		"    " + interfaceClear + "\n" +
-		"    " + interfaceClearAll + "\n\n"
-
-	if verbose {
-		text += outputFormat
-	}
+func neighborFormat() string {
+	return "[bridge-index] local-port <===> remote-host remote-device[mac] remote-uname\n" +
+		"      chassis subtype id port subtype id [caps supported enabled enabled-caps]\n" +
+		"      [mgmt address...] [age duration]"
+}
 
-	return text
+func fdbFormat() string {
+	return fmt.Sprintf("mac vid %s ifname %s",
+		bold("[bridge-index]"),
+		green("learned|static|self"),
+	)
 }
 
 func maxMe(a *int, b int) {
@@ -395,24 +896,45 @@ func (xs SortedInterfaces) Swap(i, j int) {
 }
 
 // produce a textural representation of a switch
-func showSwitch(c *dsnmp.SwitchControllerSnmp) {
+func showSwitch(c dsnmp.SwitchController, args []string) error {
+
+	if len(args) != 0 {
+		return usagef("show takes no arguments")
+	}
 
 	ifxs_, err := c.GetInterfaces()
+	if err != nil {
+		return err
+	}
 	ifxs := SortedInterfaces(ifxs_)
 	sort.Sort(ifxs)
 
-	if err != nil {
-		log.Fatal(err)
+	if structured() {
+		vlans, err := c.GetVlans()
+		if err != nil {
+			return err
+		}
+		nbrs, err := c.GetNeighbors()
+		if err != nil {
+			return err
+		}
+		return emit(switchReport{
+			Interfaces: interfaceRecords(ifxs),
+			Vlans:      vlanRecords(vlans),
+			PortVlans:  portVlanRecords(ifxs, vlans),
+			Neighbors:  neighborRecords(dsnmp.SortNeighbors(nbrs)),
+		})
 	}
+
 	log.Printf("\n%s\n", blueb("Interfaces"))
 	log.Printf("%s\n", cyanb("=========="))
 	for _, v := range ifxs {
-		log.Printf(showInterface(v))
+		log.Print(showInterface(v))
 	}
 
 	vlans, err := c.GetVlans()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	log.Printf("\n%s\n", blueb("Vlans"))
 	log.Printf("%s\n", cyanb("====="))
@@ -428,86 +950,119 @@ func showSwitch(c *dsnmp.SwitchControllerSnmp) {
 	log.Printf("%s\n", cyanb("========="))
 	nbrs, err := c.GetNeighbors()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	var widths [2]int
+	var widths [3]int
 	for _, v := range nbrs {
-		maxMe(&widths[0], len(v.RemoteName))
-		maxMe(&widths[1], len(v.RemotePortName))
+		maxMe(&widths[0], len(localPort(v)))
+		maxMe(&widths[1], len(v.RemoteName))
+		maxMe(&widths[2], len(v.RemotePortName))
 	}
 
 	f :=
-		`%2d <==> %-` +
+		`[%2d] %-` +
 			strconv.Itoa(widths[0]) +
-			`s %` +
+			`s <==> %-` +
 			strconv.Itoa(widths[1]) +
+			`s %` +
+			strconv.Itoa(widths[2]) +
 			`s[%s] '%.64s'`
 
-	for _, v := range nbrs {
+	for _, v := range dsnmp.SortNeighbors(nbrs) {
 		log.Printf(f,
-			v.LocalIfIndex,
+			v.BridgeIfIndex,
+			localPort(v),
 			v.RemoteName,
 			v.RemotePortName,
 			hex.EncodeToString(v.RemoteMac),
 			v.RemoteDescription,
 		)
+		log.Print(showNeighborDetail(v))
 	}
+	return nil
 
 }
 
-func showPorts(c *dsnmp.SwitchControllerSnmp) {
+// produce the second line of a neighbor, what it advertises beyond its name
+func showNeighborDetail(n *dsnmp.Neighbor) string {
+	s := fmt.Sprintf("      chassis %s %s port %s %s",
+		cyan(chassisSubtypes[n.RemoteChassisIDSubtype]), n.RemoteChassisID,
+		cyan(portSubtypes[n.RemotePortIDSubtype]), n.RemotePortID,
+	)
+	if len(n.RemoteCapSupported) > 0 {
+		s += fmt.Sprintf(" caps %s enabled %s",
+			strings.Join(n.RemoteCapSupported, ","),
+			strings.Join(n.RemoteCapEnabled, ","),
+		)
+	}
+	for _, a := range n.RemoteManAddrs {
+		s += fmt.Sprintf(" mgmt %s", a.Address)
+	}
+	if n.Age > 0 {
+		s += fmt.Sprintf(" age %v", n.Age.Round(time.Second))
+	}
+	return s
+}
+
+// names of the lldp chassis and port id subtypes
+var chassisSubtypes = map[int]string{
+	dsnmp.ChassisIDChassisComponent: "chassis-component",
+	dsnmp.ChassisIDInterfaceAlias:   "ifalias",
+	dsnmp.ChassisIDPortComponent:    "port-component",
+	dsnmp.ChassisIDMacAddress:       "mac",
+	dsnmp.ChassisIDNetworkAddress:   "address",
+	dsnmp.ChassisIDInterfaceName:    "ifname",
+	dsnmp.ChassisIDLocal:            "local",
+}
+
+var portSubtypes = map[int]string{
+	dsnmp.PortIDInterfaceAlias: "ifalias",
+	dsnmp.PortIDPortComponent:  "port-component",
+	dsnmp.PortIDMacAddress:     "mac",
+	dsnmp.PortIDNetworkAddress: "address",
+	dsnmp.PortIDInterfaceName:  "ifname",
+	dsnmp.PortIDAgentCircuitID: "circuit-id",
+	dsnmp.PortIDLocal:          "local",
+}
+
+// localPort names the switch port a neighbor was seen on, by the port id
+// the switch advertises or else the lldp port number.
+func localPort(n *dsnmp.Neighbor) string {
+	if n.LocalPortID != "" {
+		return n.LocalPortID
+	}
+	return fmt.Sprintf("lldp:%d", n.LocalPort)
+}
+
+func showPorts(c dsnmp.SwitchController, args []string) error {
+	if len(args) != 0 {
+		return usagef("show-ports takes no arguments")
+	}
 	ifxs, err := c.GetInterfaces()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	vlans, err := c.GetVlans()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if structured() {
+		return emit(portVlanRecords(ifxs, vlans))
 	}
 	showPortVlans(ifxs, vlans)
+	return nil
 }
 
 func showPortVlans(ifxs []dsnmp.Interface, vlans []dsnmp.Vlan) {
 
-	num_bridge_ifxs := 0
-	for _, i := range ifxs {
-		if i.BridgeIndex > 0 {
-			num_bridge_ifxs++
-		}
-	}
-
 	if len(vlans) == 0 {
 		return
 	}
 
-	type PortVlan struct {
-		Untagged, Trunk []int
-	}
-
-	portMap := make(map[int]*PortVlan)
-	for i := 1; i <= num_bridge_ifxs; i++ {
-		portMap[i] = &PortVlan{}
-	}
-
-	for _, v := range vlans {
-
-		for i := 0; i < num_bridge_ifxs; i++ {
-			if dsnmp.IsPortSet(i, v.EgressPorts) {
-				portMap[i+1].Trunk = append(portMap[i+1].Trunk, v.Index)
-			}
-		}
-
-		for i := 0; i < num_bridge_ifxs; i++ {
-			if dsnmp.IsPortSet(i, v.AccessPorts) {
-				portMap[i+1].Untagged = append(portMap[i+1].Untagged, v.Index)
-			}
-		}
-
-	}
-
-	for i, p := range portMap {
-		log.Printf("%4d  Trunked  %v \n      Untagged %v\n\n", i, p.Trunk, p.Untagged)
+	for _, p := range portVlanRecords(ifxs, vlans) {
+		log.Printf("%4d  Trunked  %v \n      Untagged %v\n\n",
+			p.BridgePort, p.Egress, p.Untagged)
 	}
 
 }
@@ -550,6 +1105,16 @@ func showInterface(i dsnmp.Interface) string {
 		s += yellow("op:lower-down ")
 	}
 
+	if i.BridgeIndex != 0 {
+		s += fmt.Sprintf("pvid:%d ", i.Pvid)
+		if i.AcceptableFrameTypes == dsnmp.AdmitOnlyVlanTagged {
+			s += "tagged-only "
+		}
+		if i.IngressFiltering {
+			s += "ingress-filter "
+		}
+	}
+
 	return s
 }
 
@@ -598,26 +1163,33 @@ func showVlan(v dsnmp.Vlan) string {
 	return s
 }
 
-func listVlans(c *dsnmp.SwitchControllerSnmp) {
+func listVlans(c dsnmp.SwitchController) error {
 
 	vlans, err := c.GetVlans()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if structured() {
+		return emit(vlanRecords(vlans))
 	}
 	for _, v := range vlans {
 		allPorts, err := portmapMerge(v.AccessPorts, v.EgressPorts)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		log.Printf("%s %d %s", v.Name, v.Index, portmapToString(allPorts))
 	}
+	return nil
 
 }
 
-func listInterfaces(c *dsnmp.SwitchControllerSnmp) {
+func listInterfaces(c dsnmp.SwitchController) error {
 	interfaces, err := c.GetInterfaces()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if structured() {
+		return emit(interfaceRecords(interfaces))
 	}
 	for _, i := range interfaces {
 		log.Printf("%d %d %d %d %d",
@@ -628,5 +1200,358 @@ func listInterfaces(c *dsnmp.SwitchControllerSnmp) {
 			i.OpStatus,
 		)
 	}
+	return nil
 
 }
+
+//##
+// ### Structured Output ~~~~~~~
+//##
+
+// The records below are what -output json, yaml and csv write. Their field
+// names are the schema programs read, change them with care.
+
+type interfaceRecord struct {
+	BridgeIndex      int    `json:"bridge_index" yaml:"bridge_index"`
+	IfIndex          int    `json:"ifindex" yaml:"ifindex"`
+	Label            string `json:"label" yaml:"label"`
+	Kind             int    `json:"kind" yaml:"kind"`
+	AdminStatus      string `json:"admin_status" yaml:"admin_status"`
+	OpStatus         string `json:"op_status" yaml:"op_status"`
+	Pvid             int    `json:"pvid" yaml:"pvid"`
+	TaggedOnly       bool   `json:"tagged_only" yaml:"tagged_only"`
+	IngressFiltering bool   `json:"ingress_filtering" yaml:"ingress_filtering"`
+}
+
+type vlanRecord struct {
+	Vid      int    `json:"vid" yaml:"vid"`
+	Name     string `json:"name" yaml:"name"`
+	Egress   []int  `json:"egress" yaml:"egress"`
+	Untagged []int  `json:"untagged" yaml:"untagged"`
+}
+
+type portVlanRecord struct {
+	BridgePort int   `json:"bridge_port" yaml:"bridge_port"`
+	Pvid       int   `json:"pvid" yaml:"pvid"`
+	Egress     []int `json:"egress" yaml:"egress"`
+	Untagged   []int `json:"untagged" yaml:"untagged"`
+}
+
+type neighborRecord struct {
+	LocalPort              int      `json:"local_port" yaml:"local_port"`
+	LocalPortID            string   `json:"local_port_id" yaml:"local_port_id"`
+	LocalIfIndex           int      `json:"local_ifindex" yaml:"local_ifindex"`
+	BridgePort             int      `json:"bridge_port" yaml:"bridge_port"`
+	RemIndex               int      `json:"rem_index" yaml:"rem_index"`
+	RemoteChassisID        string   `json:"remote_chassis_id" yaml:"remote_chassis_id"`
+	RemoteChassisIDSubtype string   `json:"remote_chassis_id_subtype" yaml:"remote_chassis_id_subtype"`
+	RemotePortID           string   `json:"remote_port_id" yaml:"remote_port_id"`
+	RemotePortIDSubtype    string   `json:"remote_port_id_subtype" yaml:"remote_port_id_subtype"`
+	RemoteMac              string   `json:"remote_mac" yaml:"remote_mac"`
+	RemoteName             string   `json:"remote_name" yaml:"remote_name"`
+	RemotePortDesc         string   `json:"remote_port_desc" yaml:"remote_port_desc"`
+	RemoteDescription      string   `json:"remote_description" yaml:"remote_description"`
+	CapSupported           []string `json:"cap_supported" yaml:"cap_supported"`
+	CapEnabled             []string `json:"cap_enabled" yaml:"cap_enabled"`
+	ManagementAddresses    []string `json:"management_addresses" yaml:"management_addresses"`
+	AgeSeconds             int      `json:"age_seconds" yaml:"age_seconds"`
+}
+
+type fdbRecord struct {
+	Mac        string `json:"mac" yaml:"mac"`
+	Vlan       int    `json:"vlan" yaml:"vlan"`
+	BridgePort int    `json:"bridge_port" yaml:"bridge_port"`
+	IfIndex    int    `json:"ifindex" yaml:"ifindex"`
+	IfName     string `json:"ifname" yaml:"ifname"`
+	Status     string `json:"status" yaml:"status"`
+}
+
+// everything show reads
+type switchReport struct {
+	Interfaces []interfaceRecord `json:"interfaces" yaml:"interfaces"`
+	Vlans      []vlanRecord      `json:"vlans" yaml:"vlans"`
+	PortVlans  []portVlanRecord  `json:"port_vlans" yaml:"port_vlans"`
+	Neighbors  []neighborRecord  `json:"neighbors" yaml:"neighbors"`
+}
+
+// names of ifAdminStatus and ifOperStatus values, IF-MIB
+var ifStatusNames = map[int]string{
+	1: "up",
+	2: "down",
+	3: "testing",
+	4: "unknown",
+	5: "dormant",
+	6: "notPresent",
+	7: "lowerLayerDown",
+}
+
+// structured returns whether the output is for programs rather than people.
+func structured() bool {
+	return outputFormat != "table"
+}
+
+// emit writes v, a record list or a switchReport, in the output format
+func emit(v interface{}) error {
+	var err error
+	switch outputFormat {
+	case "json":
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		err = e.Encode(v)
+	case "yaml":
+		var out []byte
+		out, err = yaml.Marshal(v)
+		if err == nil {
+			_, err = os.Stdout.Write(out)
+		}
+	case "csv":
+		err = emitCsv(v)
+	}
+	return err
+}
+
+// emitCsv writes v as csv with a header row, list values joined by ;. The
+// tables of a switchReport are written one after the other, each after a
+// # name line and separated by blank lines.
+func emitCsv(v interface{}) error {
+	if r, ok := v.(switchReport); ok {
+		sections := []struct {
+			name  string
+			table interface{}
+		}{
+			{"interfaces", r.Interfaces},
+			{"vlans", r.Vlans},
+			{"port_vlans", r.PortVlans},
+			{"neighbors", r.Neighbors},
+		}
+		for i, x := range sections {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("# %s\n", x.name)
+			err := emitCsv(x.table)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var rows [][]string
+	switch records := v.(type) {
+	case []interfaceRecord:
+		rows = append(rows, []string{"bridge_index", "ifindex", "label", "kind",
+			"admin_status", "op_status", "pvid", "tagged_only", "ingress_filtering"})
+		for _, r := range records {
+			rows = append(rows, []string{
+				strconv.Itoa(r.BridgeIndex),
+				strconv.Itoa(r.IfIndex),
+				r.Label,
+				strconv.Itoa(r.Kind),
+				r.AdminStatus,
+				r.OpStatus,
+				strconv.Itoa(r.Pvid),
+				strconv.FormatBool(r.TaggedOnly),
+				strconv.FormatBool(r.IngressFiltering),
+			})
+		}
+	case []vlanRecord:
+		rows = append(rows, []string{"vid", "name", "egress", "untagged"})
+		for _, r := range records {
+			rows = append(rows, []string{
+				strconv.Itoa(r.Vid),
+				r.Name,
+				joinInts(r.Egress),
+				joinInts(r.Untagged),
+			})
+		}
+	case []portVlanRecord:
+		rows = append(rows, []string{"bridge_port", "pvid", "egress", "untagged"})
+		for _, r := range records {
+			rows = append(rows, []string{
+				strconv.Itoa(r.BridgePort),
+				strconv.Itoa(r.Pvid),
+				joinInts(r.Egress),
+				joinInts(r.Untagged),
+			})
+		}
+	case []neighborRecord:
+		rows = append(rows, []string{"local_port", "local_port_id", "local_ifindex",
+			"bridge_port", "rem_index", "remote_chassis_id",
+			"remote_chassis_id_subtype", "remote_port_id", "remote_port_id_subtype",
+			"remote_mac", "remote_name", "remote_port_desc", "remote_description",
+			"cap_supported", "cap_enabled", "management_addresses", "age_seconds"})
+		for _, r := range records {
+			rows = append(rows, []string{
+				strconv.Itoa(r.LocalPort),
+				r.LocalPortID,
+				strconv.Itoa(r.LocalIfIndex),
+				strconv.Itoa(r.BridgePort),
+				strconv.Itoa(r.RemIndex),
+				r.RemoteChassisID,
+				r.RemoteChassisIDSubtype,
+				r.RemotePortID,
+				r.RemotePortIDSubtype,
+				r.RemoteMac,
+				r.RemoteName,
+				r.RemotePortDesc,
+				r.RemoteDescription,
+				strings.Join(r.CapSupported, ";"),
+				strings.Join(r.CapEnabled, ";"),
+				strings.Join(r.ManagementAddresses, ";"),
+				strconv.Itoa(r.AgeSeconds),
+			})
+		}
+	case []fdbRecord:
+		rows = append(rows, []string{"mac", "vlan", "bridge_port", "ifindex",
+			"ifname", "status"})
+		for _, r := range records {
+			rows = append(rows, []string{
+				r.Mac,
+				strconv.Itoa(r.Vlan),
+				strconv.Itoa(r.BridgePort),
+				strconv.Itoa(r.IfIndex),
+				r.IfName,
+				r.Status,
+			})
+		}
+	default:
+		return fmt.Errorf("no csv form for %T", v)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	err := w.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+func interfaceRecords(ifxs []dsnmp.Interface) []interfaceRecord {
+	records := make([]interfaceRecord, 0, len(ifxs))
+	for _, i := range ifxs {
+		records = append(records, interfaceRecord{
+			BridgeIndex:      i.BridgeIndex,
+			IfIndex:          i.Index,
+			Label:            i.Label,
+			Kind:             i.Kind,
+			AdminStatus:      ifStatusNames[i.AdminStatus],
+			OpStatus:         ifStatusNames[i.OpStatus],
+			Pvid:             i.Pvid,
+			TaggedOnly:       i.AcceptableFrameTypes == dsnmp.AdmitOnlyVlanTagged,
+			IngressFiltering: i.IngressFiltering,
+		})
+	}
+	return records
+}
+
+func vlanRecords(vlans []dsnmp.Vlan) []vlanRecord {
+	records := make([]vlanRecord, 0, len(vlans))
+	for _, v := range vlans {
+		records = append(records, vlanRecord{
+			Vid:      v.Index,
+			Name:     v.Name,
+			Egress:   portmapToInts(v.EgressPorts),
+			Untagged: portmapToInts(v.AccessPorts),
+		})
+	}
+	return records
+}
+
+// portVlanRecords gathers the vlans of each bridge port, in bridge port
+// order.
+func portVlanRecords(
+	ifxs []dsnmp.Interface, vlans []dsnmp.Vlan) []portVlanRecord {
+
+	var records []portVlanRecord
+	for _, i := range ifxs {
+		if i.BridgeIndex == 0 {
+			continue
+		}
+		p := portVlanRecord{
+			BridgePort: i.BridgeIndex,
+			Pvid:       i.Pvid,
+			Egress:     []int{},
+			Untagged:   []int{},
+		}
+		for _, v := range vlans {
+			if dsnmp.IsPortSet(i.BridgeIndex-1, v.EgressPorts) {
+				p.Egress = append(p.Egress, v.Index)
+			}
+			if dsnmp.IsPortSet(i.BridgeIndex-1, v.AccessPorts) {
+				p.Untagged = append(p.Untagged, v.Index)
+			}
+		}
+		records = append(records, p)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].BridgePort < records[j].BridgePort
+	})
+	return records
+}
+
+func neighborRecords(nbrs []*dsnmp.Neighbor) []neighborRecord {
+	records := make([]neighborRecord, 0, len(nbrs))
+	for _, n := range nbrs {
+		r := neighborRecord{
+			LocalPort:              n.LocalPort,
+			LocalPortID:            n.LocalPortID,
+			LocalIfIndex:           n.LocalIfIndex,
+			BridgePort:             n.BridgeIfIndex,
+			RemIndex:               n.RemIndex,
+			RemoteChassisID:        n.RemoteChassisID,
+			RemoteChassisIDSubtype: chassisSubtypes[n.RemoteChassisIDSubtype],
+			RemotePortID:           n.RemotePortID,
+			RemotePortIDSubtype:    portSubtypes[n.RemotePortIDSubtype],
+			RemoteName:             n.RemoteName,
+			RemotePortDesc:         n.RemotePortName,
+			RemoteDescription:      n.RemoteDescription,
+			CapSupported:           append([]string{}, n.RemoteCapSupported...),
+			CapEnabled:             append([]string{}, n.RemoteCapEnabled...),
+			ManagementAddresses:    []string{},
+			AgeSeconds:             int(n.Age / time.Second),
+		}
+		if len(n.RemoteMac) > 0 {
+			r.RemoteMac = net.HardwareAddr(n.RemoteMac).String()
+		}
+		for _, a := range n.RemoteManAddrs {
+			r.ManagementAddresses = append(r.ManagementAddresses, a.Address)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func fdbRecords(fdb []dsnmp.FdbEntry) []fdbRecord {
+	records := make([]fdbRecord, 0, len(fdb))
+	for _, e := range fdb {
+		records = append(records, fdbRecord{
+			Mac:        e.Mac.String(),
+			Vlan:       e.Vlan,
+			BridgePort: e.BridgePort,
+			IfIndex:    e.IfIndex,
+			IfName:     e.IfName,
+			Status:     e.Status.String(),
+		})
+	}
+	return records
+}
+
+// portmapToInts lists the ports set in a portlist, numbered from 1.
+func portmapToInts(portmap []byte) []int {
+	ports := []int{}
+	for i := 0; i < len(portmap)*8; i++ {
+		if dsnmp.IsPortSet(i, portmap) {
+			ports = append(ports, i+1)
+		}
+	}
+	return ports
+}
+
+func joinInts(xs []int) string {
+	ss := make([]string, len(xs))
+	for i, x := range xs {
+		ss[i] = strconv.Itoa(x)
+	}
+	return strings.Join(ss, ";")
+}
@@ -0,0 +1,384 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Recorded Agents
+ * ====================================------------------
+ *
+ * The code here plays back a recording of a switch, as written by
+ * SwitchControllerSnmp.Record or snmpwalk -On, as an Agent, so the
+ * controller can be tested and timed without the switch. Playback answers
+ * Get and GetBulk from the recorded objects and stores the values of a Set,
+ * it does not model row creation or deletion.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmptest
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/soniah/gosnmp"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Recording is an Agent that answers requests from recorded objects. It is
+// safe for concurrent use.
+type Recording struct {
+	// Latency is added to every request, to model the round trip to a switch
+	// over the management network.
+	Latency time.Duration
+
+	// MaxVarbinds limits the number of varbinds in a GetBulk response, as
+	// the message size limit of an agent does. 0 means no limit.
+	MaxVarbinds int
+
+	mu       sync.Mutex
+	objs     []recorded
+	requests int64
+}
+
+type recorded struct {
+	oid []int
+	pdu gosnmp.SnmpPDU
+}
+
+// LoadRecording reads the recording in the file at path.
+func LoadRecording(path string) (*Recording, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recording: %v", err)
+	}
+	defer f.Close()
+
+	r, err := ReadRecording(f)
+	if err != nil {
+		return nil, fmt.Errorf("recording %s: %v", path, err)
+	}
+	return r, nil
+
+}
+
+// ReadRecording reads a recording in snmpwalk -On format from in.
+func ReadRecording(in io.Reader) (*Recording, error) {
+
+	var lines []string
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, ".") && strings.Contains(line, " = "):
+			lines = append(lines, line)
+		case len(lines) > 0:
+			// long hex strings and multi line strings continue on the next
+			// lines
+			lines[len(lines)-1] += "\n" + line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	r := &Recording{}
+	for i, line := range lines {
+		pdu, ok, err := parseRecorded(line)
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %v", i+1, err)
+		}
+		if !ok {
+			continue
+		}
+		err = r.store(pdu)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+
+}
+
+// Requests returns the number of requests the recording has answered.
+func (r *Recording) Requests() int {
+
+	return int(atomic.LoadInt64(&r.requests))
+
+}
+
+// Get implements snmp.Agent.
+func (r *Recording) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+
+	r.request()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pkt := &gosnmp.SnmpPacket{PDUType: gosnmp.GetResponse}
+	for _, oid := range oids {
+		o, err := parseOid(oid)
+		if err != nil {
+			return nil, err
+		}
+		i := r.search(o)
+		if i < len(r.objs) && !indexLess(o, r.objs[i].oid) {
+			pkt.Variables = append(pkt.Variables, r.objs[i].pdu)
+		} else {
+			pkt.Variables = append(pkt.Variables,
+				gosnmp.SnmpPDU{Name: oid, Type: gosnmp.NoSuchInstance})
+		}
+	}
+	return pkt, nil
+
+}
+
+// GetBulk implements snmp.Agent.
+func (r *Recording) GetBulk(
+	oids []string,
+	nonRepeaters uint8,
+	maxRepetitions uint8) (*gosnmp.SnmpPacket, error) {
+
+	r.request()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cursors := make([][]int, len(oids))
+	for i, oid := range oids {
+		o, err := parseOid(oid)
+		if err != nil {
+			return nil, err
+		}
+		cursors[i] = o
+	}
+
+	pkt := &gosnmp.SnmpPacket{PDUType: gosnmp.GetResponse}
+	next := func(i int) {
+		j := r.search(cursors[i])
+		if j < len(r.objs) && !indexLess(cursors[i], r.objs[j].oid) {
+			j++
+		}
+		if j >= len(r.objs) {
+			pkt.Variables = append(pkt.Variables, gosnmp.SnmpPDU{
+				Name: oidString(cursors[i]),
+				Type: gosnmp.EndOfMibView,
+			})
+			return
+		}
+		cursors[i] = r.objs[j].oid
+		pkt.Variables = append(pkt.Variables, r.objs[j].pdu)
+	}
+
+	n := int(nonRepeaters)
+	if n > len(oids) {
+		n = len(oids)
+	}
+	for i := 0; i < n; i++ {
+		next(i)
+	}
+	for k := 0; k < int(maxRepetitions); k++ {
+		for i := n; i < len(oids); i++ {
+			next(i)
+		}
+	}
+	if r.MaxVarbinds > 0 && len(pkt.Variables) > r.MaxVarbinds {
+		pkt.Variables = pkt.Variables[:r.MaxVarbinds]
+	}
+	return pkt, nil
+
+}
+
+// Set implements snmp.Agent. The values are stored as given.
+func (r *Recording) Set(pdus []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error) {
+
+	r.request()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, pdu := range pdus {
+		pdu.Name = "." + strings.TrimPrefix(pdu.Name, ".")
+		err := r.store(pdu)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &gosnmp.SnmpPacket{PDUType: gosnmp.GetResponse, Variables: pdus}, nil
+
+}
+
+func (r *Recording) request() {
+
+	atomic.AddInt64(&r.requests, 1)
+	time.Sleep(r.Latency)
+
+}
+
+// search returns the position of the first object not before oid.
+func (r *Recording) search(oid []int) int {
+
+	return sort.Search(len(r.objs), func(i int) bool {
+		return !indexLess(r.objs[i].oid, oid)
+	})
+
+}
+
+// store adds pdu to the recording, replacing the object with the same name.
+func (r *Recording) store(pdu gosnmp.SnmpPDU) error {
+
+	o, err := parseOid(pdu.Name)
+	if err != nil {
+		return err
+	}
+	i := r.search(o)
+	if i < len(r.objs) && !indexLess(o, r.objs[i].oid) {
+		r.objs[i].pdu = pdu
+		return nil
+	}
+	r.objs = append(r.objs, recorded{})
+	copy(r.objs[i+1:], r.objs[i:])
+	r.objs[i] = recorded{oid: o, pdu: pdu}
+	return nil
+
+}
+
+// parseRecorded parses an object in snmpwalk -On format. Objects of types
+// the controller does not use are skipped.
+func parseRecorded(line string) (gosnmp.SnmpPDU, bool, error) {
+
+	eq := strings.Index(line, " = ")
+	pdu := gosnmp.SnmpPDU{Name: line[:eq]}
+	value := line[eq+3:]
+
+	if value == `""` {
+		pdu.Type = gosnmp.OctetString
+		pdu.Value = []byte{}
+		return pdu, true, nil
+	}
+
+	colon := strings.Index(value, ":")
+	if colon < 0 {
+		// No Such Object and friends
+		return pdu, false, nil
+	}
+	kind, value := value[:colon], strings.TrimSpace(value[colon+1:])
+
+	var err error
+	switch kind {
+	case "INTEGER":
+		pdu.Type = gosnmp.Integer
+		pdu.Value, err = strconv.Atoi(enumValue(value))
+	case "Gauge32", "Unsigned32":
+		pdu.Type = gosnmp.Gauge32
+		var x uint64
+		x, err = strconv.ParseUint(value, 10, 32)
+		pdu.Value = uint(x)
+	case "Counter32":
+		pdu.Type = gosnmp.Counter32
+		var x uint64
+		x, err = strconv.ParseUint(value, 10, 32)
+		pdu.Value = uint(x)
+	case "Counter64":
+		pdu.Type = gosnmp.Counter64
+		pdu.Value, err = strconv.ParseUint(value, 10, 64)
+	case "Timeticks":
+		pdu.Type = gosnmp.TimeTicks
+		var x uint64
+		x, err = strconv.ParseUint(enumValue(value), 10, 32)
+		pdu.Value = uint32(x)
+	case "STRING":
+		pdu.Type = gosnmp.OctetString
+		pdu.Value = []byte(unquote(value))
+	case "Hex-STRING":
+		pdu.Type = gosnmp.OctetString
+		var b []byte
+		for _, x := range strings.Fields(value) {
+			var y uint64
+			y, err = strconv.ParseUint(x, 16, 8)
+			if err != nil {
+				break
+			}
+			b = append(b, byte(y))
+		}
+		pdu.Value = b
+	case "OID":
+		pdu.Type = gosnmp.ObjectIdentifier
+		pdu.Value = value
+	case "IpAddress":
+		pdu.Type = gosnmp.IPAddress
+		pdu.Value = value
+	default:
+		return pdu, false, nil
+	}
+	if err != nil {
+		return pdu, false, fmt.Errorf("bad %s value for %s: %q", kind, pdu.Name, value)
+	}
+
+	return pdu, true, nil
+
+}
+
+// enumValue returns the number of an enumerated or timeticks value as
+// printed by snmpwalk, up(1) or (1234) 0:00:12.34.
+func enumValue(s string) string {
+
+	open := strings.Index(s, "(")
+	end := strings.Index(s, ")")
+	if open < 0 || end < open {
+		return s
+	}
+	return s[open+1 : end]
+
+}
+
+// unquote returns the contents of a string as printed by snmpwalk.
+func unquote(s string) string {
+
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return strings.Replace(s[1:len(s)-1], `\"`, `"`, -1)
+
+}
+
+func parseOid(oid string) ([]int, error) {
+
+	parts := strings.Split(strings.Trim(oid, "."), ".")
+	o := make([]int, len(parts))
+	for i, p := range parts {
+		x, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("bad oid %q", oid)
+		}
+		o[i] = x
+	}
+	return o, nil
+
+}
+
+func oidString(oid []int) string {
+
+	s := make([]string, len(oid))
+	for i, x := range oid {
+		s[i] = strconv.Itoa(x)
+	}
+	return "." + strings.Join(s, ".")
+
+}
+
+// indexLess orders oids the way an agent does.
+func indexLess(a, b []int) bool {
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+
+}
@@ -0,0 +1,122 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter Switch Controller Library - Controller Interface
+ * ======================================================
+ *
+ * The code here defines the vendor neutral switch controller interface that
+ * applications program against, along with a registry of drivers so that
+ * a backend can be selected by name at runtime.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+///            ----------------------------------------------------------------
+/// SwitchController
+///  --------------------------------------------------------------------------
+
+// A SwitchController is the set of operations a deter switch driver must
+// provide. Ports are identified by bridge index and vlans by vid, a PortMap
+// from GetPortMap turns the other names of a port into its bridge index.
+type SwitchController interface {
+	GetInterfaces() ([]Interface, error)
+	GetVlans() ([]Vlan, error)
+	GetNeighbors() (map[NeighborKey]*Neighbor, error)
+	GetPortMap() (*PortMap, error)
+	GetFdb() ([]FdbEntry, error)
+	GetModules() (map[int]int, error)
+
+	CreateVlan(vid int) error
+	DeleteVlan(vid int) error
+
+	SetPortAccess(ports []int, vid int) error
+
+	// SetPortTrunk makes ports trunks of vids, with the native vlan and mode
+	// of opts, see TrunkOptions.
+	SetPortTrunk(ports []int, vids []int, opts TrunkOptions) error
+
+	// MovePortAccess makes ports access ports of vid and removes them from
+	// every other vlan.
+	MovePortAccess(ports []int, vid int) error
+
+	ClearPorts(ports []int) error
+	ClearVlans(vids []int) error
+	ClearPortVlans(port int, vids []int) error
+	ClearVlanPorts(vid int, ports []int) error
+
+	// Close releases any resources (connections, sessions) held by the
+	// controller.
+	Close() error
+}
+
+// A Driver creates a SwitchController for the switch at the given address.
+// Drivers use the parts of opts that apply to them, a nil opts means
+// defaults.
+type Driver func(address string, opts *Options) (SwitchController, error)
+
+// DefaultDriver is the name of the driver used when none is specified.
+const DefaultDriver = "snmp"
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// RegisterDriver makes a driver available under the provided name. It is
+// intended to be called from the init function of the package implementing
+// the driver and panics if the name is taken or the driver is nil.
+func RegisterDriver(name string, driver Driver) {
+
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if driver == nil {
+		panic("snmp: RegisterDriver driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("snmp: RegisterDriver called twice for driver " + name)
+	}
+	drivers[name] = driver
+
+}
+
+// Drivers returns the sorted names of all registered drivers.
+func Drivers() []string {
+
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	var names []string
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+
+}
+
+// NewSwitchController creates a controller for the switch at the specified
+// address using the named driver. An empty name selects DefaultDriver.
+func NewSwitchController(
+	driver, address string, opts *Options) (SwitchController, error) {
+
+	if driver == "" {
+		driver = DefaultDriver
+	}
+
+	driversMu.RLock()
+	d, ok := drivers[driver]
+	driversMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown switch driver %q (have %v)",
+			driver, Drivers())
+	}
+	return d(address, opts)
+
+}
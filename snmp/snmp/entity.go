@@ -0,0 +1,133 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Modules
+ * ====================================----------
+ *
+ * The code here finds the module, line card or stack member, an interface
+ * is on. ENTITY-MIB describes the switch as a tree of physical entities,
+ * ports contained in modules contained in the chassis, and entAliasMapping
+ * relates the port entities to interfaces. Switches that do not implement
+ * it usually say the module in the interface name, Gi2/0/12 is on module 2.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the entPhysicalClass of modules, ENTITY-MIB PhysicalClass
+const entityClassModule = 9
+
+// GetModules returns the module of each interface keyed by ifIndex. The
+// module is the entPhysicalParentRelPos of the module entity the interface
+// is in, or failing that the module in its name, see ModuleFromName.
+// Interfaces on no module are left out.
+func (c *SwitchControllerSnmp) GetModules() (map[int]int, error) {
+
+	ports, err := c.GetPortMap()
+	if err != nil {
+		return nil, err
+	}
+	return c.GetModulesWith(ports)
+
+}
+
+// GetModulesWith is GetModules with the port map of the switch given, see
+// GetNeighborsWith.
+func (c *SwitchControllerSnmp) GetModulesWith(ports *PortMap) (map[int]int, error) {
+
+	modules, err := c.getEntityModules()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range ports.Ports() {
+		if _, ok := modules[p.IfIndex]; ok {
+			continue
+		}
+		name := p.Name
+		if name == "" {
+			name = p.Descr
+		}
+		if m, ok := ModuleFromName(name); ok {
+			modules[p.IfIndex] = m
+		}
+	}
+	return modules, nil
+
+}
+
+// getEntityModules reads the modules of the interfaces from ENTITY-MIB,
+// keyed by ifIndex.
+func (c *SwitchControllerSnmp) getEntityModules() (map[int]int, error) {
+
+	rows, err := c.walkTable(
+		entPhysicalPropertyOid(4),
+		entPhysicalPropertyOid(5),
+		entPhysicalPropertyOid(6),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error reading physical entities: %v", err)
+	}
+	entities := make(map[int]tableRow)
+	for _, r := range rows {
+		entities[r.last()] = r
+	}
+
+	rows, err = c.walkTable(entAliasMappingOid)
+	if err != nil {
+		return nil, fmt.Errorf("error reading entity alias mapping: %v", err)
+	}
+
+	modules := make(map[int]int)
+	for _, r := range rows {
+		v, _ := r.cell(entAliasMappingOid)
+		oid, _ := v.Value.(string)
+		prefix := interfacePropertyOid(1) + "."
+		oid = "." + strings.TrimPrefix(oid, ".")
+		if !strings.HasPrefix(oid, prefix) {
+			continue
+		}
+		ifIndex, err := strconv.Atoi(oid[len(prefix):])
+		if err != nil {
+			continue
+		}
+
+		// walk up the containment tree to the module, the depth bounds a
+		// loop in a broken tree
+		e, ok := entities[r.index[0]]
+		for depth := 0; ok && depth < 16; depth++ {
+			if e.integer(entPhysicalPropertyOid(5)) == entityClassModule {
+				modules[ifIndex] = e.integer(entPhysicalPropertyOid(6))
+				break
+			}
+			e, ok = entities[e.integer(entPhysicalPropertyOid(4))]
+		}
+	}
+	return modules, nil
+
+}
+
+// an interface name that ends in slash separated numbers
+var moduleName = regexp.MustCompile(`(\d+)(/\d+)+$`)
+
+// ModuleFromName returns the module in an interface name, the first of the
+// slash separated numbers the name ends in. Gi2/0/12, Ethernet2/12 and
+// ge-2/0/12 are on module 2. Names like swp12 say no module.
+func ModuleFromName(name string) (int, bool) {
+
+	m := moduleName.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	x, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+	return x, true
+
+}
@@ -0,0 +1,86 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Errors
+ * ====================================---------
+ *
+ * The code here defines the errors reported when an agent rejects a request,
+ * so callers can tell what failed and why.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"fmt"
+	"github.com/soniah/gosnmp"
+)
+
+// A SetError is returned when an agent responds to a SET request with a non
+// zero error-status. Oid is the object the agent blamed via the error-index,
+// or the first object of the request if the index does not point into it.
+type SetError struct {
+	Oid    string
+	Status gosnmp.SNMPError
+	Index  int
+}
+
+func (e *SetError) Error() string {
+	return fmt.Sprintf("snmp set %s failed: %s (error-index %d)",
+		e.Oid, ErrorStatusName(e.Status), e.Index)
+}
+
+// A StepError is returned when a change that could not be sent as a single
+// request failed part way through the ordered sequence of writes it was
+// broken into. Steps before Step were applied, Err is why Step failed.
+type StepError struct {
+	Step, Steps int
+	Oid         string
+	Err         error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %d of %d (%s) failed, earlier steps applied: %v",
+		e.Step, e.Steps, e.Oid, e.Err)
+}
+
+// IsSetError returns whether err is a SetError with the given status, either
+// directly or as the cause of a StepError.
+func IsSetError(err error, status gosnmp.SNMPError) bool {
+	if s, ok := err.(*StepError); ok {
+		err = s.Err
+	}
+	e, ok := err.(*SetError)
+	return ok && e.Status == status
+}
+
+// ErrorStatusName returns the RFC 3416 name of an snmp error-status.
+func ErrorStatusName(status gosnmp.SNMPError) string {
+
+	if int(status) < len(errorStatusNames) {
+		return errorStatusNames[status]
+	}
+	return fmt.Sprintf("error-status(%d)", status)
+
+}
+
+// indexed by error-status, RFC 3416 section 3
+var errorStatusNames = []string{
+	"noError",
+	"tooBig",
+	"noSuchName",
+	"badValue",
+	"readOnly",
+	"genErr",
+	"noAccess",
+	"wrongType",
+	"wrongLength",
+	"wrongEncoding",
+	"wrongValue",
+	"noCreation",
+	"inconsistentValue",
+	"resourceUnavailable",
+	"commitFailed",
+	"undoFailed",
+	"authorizationError",
+	"notWritable",
+	"inconsistentName",
+}
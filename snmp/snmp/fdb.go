@@ -0,0 +1,199 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Forwarding Database
+ * ====================================----------------------
+ *
+ * The code here reads the mac addresses the switch has learned, to find the
+ * port of a host that does not speak LLDP, a node booting over the network
+ * for example. The Q-BRIDGE dot1qTpFdbTable keeps an entry per filtering
+ * database, which is related to a vlan through dot1qVlanCurrentTable. Switches
+ * without Q-BRIDGE support only have the BRIDGE-MIB dot1dTpFdbTable, which
+ * knows nothing of vlans.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"fmt"
+	"net"
+)
+
+// An FdbStatus is the status of a forwarding database entry, the values of
+// dot1qTpFdbStatus and dot1dTpFdbStatus.
+type FdbStatus int
+
+const (
+	FdbOther   FdbStatus = 1
+	FdbInvalid FdbStatus = 2
+	FdbLearned FdbStatus = 3
+	FdbSelf    FdbStatus = 4
+
+	// FdbStatic is mgmt in the MIBs, an entry of the static address tables
+	FdbStatic FdbStatus = 5
+)
+
+var fdbStatusNames = map[FdbStatus]string{
+	FdbOther:   "other",
+	FdbInvalid: "invalid",
+	FdbLearned: "learned",
+	FdbSelf:    "self",
+	FdbStatic:  "static",
+}
+
+func (s FdbStatus) String() string {
+
+	if name, ok := fdbStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("status(%d)", int(s))
+
+}
+
+// An FdbEntry is a mac address in the forwarding database of the switch.
+// Vlan is the vlan the address was learned on, 0 when the switch does not
+// say, and FdbID the filtering database it is in. BridgePort is 0 for
+// addresses not reached through a port, the addresses of the switch itself.
+// IfIndex and IfName are those of the interface of the bridge port.
+type FdbEntry struct {
+	Mac        net.HardwareAddr
+	Vlan       int
+	FdbID      int
+	BridgePort int
+	IfIndex    int
+	IfName     string
+	Status     FdbStatus
+}
+
+// GetFdb fetches the forwarding database of the switch from
+// dot1qTpFdbTable, or from dot1dTpFdbTable if the switch has no entries in
+// the former. The entries are in the order of the tables, by filtering
+// database and then mac address.
+func (c *SwitchControllerSnmp) GetFdb() ([]FdbEntry, error) {
+
+	ports, err := c.GetPortMap()
+	if err != nil {
+		return nil, err
+	}
+	return c.GetFdbWith(ports)
+
+}
+
+// GetFdbWith is GetFdb with the port map of the switch given, see
+// GetNeighborsWith.
+func (c *SwitchControllerSnmp) GetFdbWith(ports *PortMap) ([]FdbEntry, error) {
+
+	fdb, err := c.getQFdb()
+	if err != nil {
+		return nil, err
+	}
+	if len(fdb) == 0 {
+		fdb, err = c.getFdb()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range fdb {
+		if p, ok := ports.ByBridgePort(fdb[i].BridgePort); ok {
+			fdb[i].IfIndex = p.IfIndex
+			fdb[i].IfName = p.Name
+		}
+	}
+	return fdb, nil
+
+}
+
+// getQFdb reads dot1qTpFdbTable, indexed by filtering database and mac.
+func (c *SwitchControllerSnmp) getQFdb() ([]FdbEntry, error) {
+
+	rows, err := c.walkTable(qFdbPropertyOid(2), qFdbPropertyOid(3))
+	if err != nil {
+		return nil, fmt.Errorf("error reading forwarding database: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	vlans, err := c.getFdbVlans()
+	if err != nil {
+		return nil, err
+	}
+
+	var fdb []FdbEntry
+	for _, r := range rows {
+		if len(r.index) != 7 {
+			continue
+		}
+		e := FdbEntry{
+			Mac:        indexMac(r.index[1:]),
+			FdbID:      r.index[0],
+			BridgePort: r.integer(qFdbPropertyOid(2)),
+			Status:     FdbStatus(r.integer(qFdbPropertyOid(3))),
+		}
+		vids, ok := vlans[e.FdbID]
+		switch {
+		// without the vlan table, take the fdb id to be the vid, as it is
+		// on most switches
+		case len(vlans) == 0:
+			e.Vlan = e.FdbID
+		// a database shared by several vlans does not tell which one
+		case ok && len(vids) == 1:
+			e.Vlan = vids[0]
+		}
+		fdb = append(fdb, e)
+	}
+	return fdb, nil
+
+}
+
+// getFdbVlans reads dot1qVlanFdbId and returns the vlans of each filtering
+// database.
+func (c *SwitchControllerSnmp) getFdbVlans() (map[int][]int, error) {
+
+	rows, err := c.walkTable(currentVlanPropertyOid(3))
+	if err != nil {
+		return nil, fmt.Errorf("error reading vlan filtering databases: %v", err)
+	}
+
+	vlans := make(map[int][]int)
+	for _, r := range rows {
+		id := r.integer(currentVlanPropertyOid(3))
+		vlans[id] = append(vlans[id], r.last())
+	}
+	return vlans, nil
+
+}
+
+// getFdb reads dot1dTpFdbTable, indexed by mac.
+func (c *SwitchControllerSnmp) getFdb() ([]FdbEntry, error) {
+
+	rows, err := c.walkTable(fdbPropertyOid(2), fdbPropertyOid(3))
+	if err != nil {
+		return nil, fmt.Errorf("error reading forwarding database: %v", err)
+	}
+
+	var fdb []FdbEntry
+	for _, r := range rows {
+		if len(r.index) != 6 {
+			continue
+		}
+		fdb = append(fdb, FdbEntry{
+			Mac:        indexMac(r.index),
+			BridgePort: r.integer(fdbPropertyOid(2)),
+			Status:     FdbStatus(r.integer(fdbPropertyOid(3))),
+		})
+	}
+	return fdb, nil
+
+}
+
+// indexMac returns the mac address spelled out by a table index.
+func indexMac(index []int) net.HardwareAddr {
+
+	mac := make(net.HardwareAddr, len(index))
+	for i, x := range index {
+		mac[i] = byte(x)
+	}
+	return mac
+
+}
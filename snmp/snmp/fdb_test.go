@@ -0,0 +1,107 @@
+package snmp
+
+import (
+	"testing"
+)
+
+// The filtering databases of a switch that numbers them on its own, vlan
+// 10 has database 2 and vlans 20 and 30 share database 3. Database 9 is of
+// no vlan.
+const fdbVlans = `
+.1.3.6.1.2.1.17.7.1.4.2.1.3.0.1 = Gauge32: 1
+.1.3.6.1.2.1.17.7.1.4.2.1.3.0.10 = Gauge32: 2
+.1.3.6.1.2.1.17.7.1.4.2.1.3.0.20 = Gauge32: 3
+.1.3.6.1.2.1.17.7.1.4.2.1.3.0.30 = Gauge32: 3
+.1.3.6.1.2.1.17.7.1.2.2.1.2.1.0.17.34.51.0.1 = INTEGER: 1
+.1.3.6.1.2.1.17.7.1.2.2.1.2.1.0.17.34.51.0.255 = INTEGER: 0
+.1.3.6.1.2.1.17.7.1.2.2.1.2.2.0.17.34.51.0.5 = INTEGER: 5
+.1.3.6.1.2.1.17.7.1.2.2.1.2.3.0.17.34.51.0.34 = INTEGER: 8
+.1.3.6.1.2.1.17.7.1.2.2.1.2.9.0.17.34.51.0.9 = INTEGER: 4
+.1.3.6.1.2.1.17.7.1.2.2.1.3.1.0.17.34.51.0.1 = INTEGER: learned(3)
+.1.3.6.1.2.1.17.7.1.2.2.1.3.1.0.17.34.51.0.255 = INTEGER: self(4)
+.1.3.6.1.2.1.17.7.1.2.2.1.3.2.0.17.34.51.0.5 = INTEGER: learned(3)
+.1.3.6.1.2.1.17.7.1.2.2.1.3.3.0.17.34.51.0.34 = INTEGER: learned(3)
+.1.3.6.1.2.1.17.7.1.2.2.1.3.9.0.17.34.51.0.9 = INTEGER: mgmt(5)
+`
+
+func TestFdb(t *testing.T) {
+
+	type entry struct {
+		mac    string
+		vlan   int
+		fdbID  int
+		port   int
+		ifName string
+		status FdbStatus
+	}
+
+	qFdb := []string{qFdbPropertyOid(2), qFdbPropertyOid(3)}
+	fdbIDs := []string{currentVlanPropertyOid(3)}
+
+	tests := []struct {
+		name string
+		drop []string
+		add  string
+		want []entry
+	}{
+		// dot1qTpFdbTable is read when it has entries, though
+		// dot1dTpFdbTable has some too
+		{"q-bridge", nil, "", []entry{
+			{"00:11:22:33:00:01", 1, 1, 1, "swp1", FdbLearned},
+			{"00:11:22:33:00:02", 1, 1, 2, "swp2", FdbLearned},
+			{"00:11:22:33:00:05", 10, 10, 5, "swp5", FdbLearned},
+			{"00:11:22:33:00:06", 10, 10, 6, "swp6", FdbLearned},
+			{"00:11:22:33:00:21", 10, 10, 8, "swp8", FdbLearned},
+			{"00:11:22:33:00:22", 20, 20, 8, "swp8", FdbLearned},
+		}},
+		{"filtering databases", append(qFdb, fdbIDs...), fdbVlans, []entry{
+			{"00:11:22:33:00:01", 1, 1, 1, "swp1", FdbLearned},
+			{"00:11:22:33:00:ff", 1, 1, 0, "", FdbSelf},
+			{"00:11:22:33:00:05", 10, 2, 5, "swp5", FdbLearned},
+			// shared by vlans 20 and 30
+			{"00:11:22:33:00:22", 0, 3, 8, "swp8", FdbLearned},
+			{"00:11:22:33:00:09", 0, 9, 4, "swp4", FdbStatic},
+		}},
+		// without dot1qVlanFdbId the database is taken for the vlan
+		{"no vlan table", fdbIDs, "", []entry{
+			{"00:11:22:33:00:01", 1, 1, 1, "swp1", FdbLearned},
+			{"00:11:22:33:00:02", 1, 1, 2, "swp2", FdbLearned},
+			{"00:11:22:33:00:05", 10, 10, 5, "swp5", FdbLearned},
+			{"00:11:22:33:00:06", 10, 10, 6, "swp6", FdbLearned},
+			{"00:11:22:33:00:21", 10, 10, 8, "swp8", FdbLearned},
+			{"00:11:22:33:00:22", 20, 20, 8, "swp8", FdbLearned},
+		}},
+		// a switch without Q-BRIDGE entries falls back to dot1dTpFdbTable,
+		// which has no vlans
+		{"bridge", qFdb, "", []entry{
+			{"00:11:22:33:00:01", 0, 0, 1, "swp1", FdbLearned},
+			{"00:11:22:33:00:02", 0, 0, 2, "swp2", FdbLearned},
+			{"00:11:22:33:00:05", 0, 0, 5, "swp5", FdbLearned},
+			{"00:11:22:33:00:06", 0, 0, 6, "swp6", FdbLearned},
+			{"00:11:22:33:00:21", 0, 0, 8, "swp8", FdbLearned},
+			{"00:11:22:33:00:22", 0, 0, 8, "swp8", FdbLearned},
+			{"00:11:22:33:00:ff", 0, 0, 0, "", FdbSelf},
+		}},
+	}
+	for _, x := range tests {
+		c := editFixture(t, x.drop, x.add)
+		fdb, err := c.GetFdb()
+		if err != nil {
+			t.Errorf("%s: %v", x.name, err)
+			continue
+		}
+		if len(fdb) != len(x.want) {
+			t.Errorf("%s: got %d entries, want %d", x.name, len(fdb), len(x.want))
+		}
+		for i, e := range fdb {
+			if i >= len(x.want) {
+				break
+			}
+			got := entry{e.Mac.String(), e.Vlan, e.FdbID, e.BridgePort, e.IfName, e.Status}
+			if got != x.want[i] {
+				t.Errorf("%s: entry %d is %+v, want %+v", x.name, i, got, x.want[i])
+			}
+		}
+	}
+
+}
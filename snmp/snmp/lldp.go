@@ -0,0 +1,389 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - LLDP Neighbors
+ * ====================================-----------------
+ *
+ * The code here reads the LLDP-MIB remote systems data to find the hosts
+ * plugged into the switch. The remote table is indexed by the LLDP local
+ * port number, which is not an ifIndex. It is related to an interface
+ * through lldpLocPortTable, see PortMap. The chassis and port ids of a
+ * neighbor are decoded according to their subtypes, so a chassis id that is
+ * a network address or an interface name is not mistaken for a mac.
+ *
+ * A port can have several neighbors, the hosts behind a hub, the virtual
+ * machines on a hypervisor or a host that moved, so neighbors are keyed by
+ * the full index of the remote table, the time mark, local port and remote
+ * index, and never by port alone.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// Values of lldpLocPortIdSubtype and lldpRemPortIdSubtype, LLDP-MIB
+// LldpPortIdSubtype.
+const (
+	PortIDInterfaceAlias = 1
+	PortIDPortComponent  = 2
+	PortIDMacAddress     = 3
+	PortIDNetworkAddress = 4
+	PortIDInterfaceName  = 5
+	PortIDAgentCircuitID = 6
+	PortIDLocal          = 7
+)
+
+// An LldpPort is a port of the switch as LLDP knows it, a row of
+// lldpLocPortTable. IfIndex is the interface it was resolved to, 0 if it
+// could not be.
+type LldpPort struct {
+	Num       int
+	IDSubtype int
+	ID        []byte
+	Desc      string
+	IfIndex   int
+}
+
+// PortID returns the port id of the port in readable form, see
+// FormatPortID.
+func (lp LldpPort) PortID() string {
+
+	return FormatPortID(lp.IDSubtype, lp.ID)
+
+}
+
+// A NeighborKey names a neighbor by the index of its lldpRemTable row. The
+// RemIndex tells apart the neighbors on one port.
+type NeighborKey struct {
+	TimeMark  int
+	LocalPort int
+	RemIndex  int
+}
+
+// A Neighbor is a host plugged into the switch as reported by LLDP.
+// LocalPort is the LLDP local port number the neighbor was seen on,
+// LocalIfIndex and BridgeIfIndex the interface and bridge port that port
+// resolves to, 0 when it does not. LocalPortID and LocalPortDesc are the
+// port id and description the switch advertises for the port.
+//
+// The Remote fields are what the neighbor advertises. The ids are decoded
+// according to their subtypes, see FormatChassisID and FormatPortID.
+// RemoteMac is the port id when that is a mac address, as lldpd sends by
+// default, or else the chassis id when that is one, nil otherwise. The port
+// id comes first as it is the address of the interface plugged into the
+// switch, where the chassis id of a host with several interfaces is the
+// address of any one of them, and it is what RemoteMac always held before
+// the subtypes were read. RemotePortName is the port description.
+type Neighbor struct {
+	LocalPort          int
+	LocalPortID        string
+	LocalPortIDSubtype int
+	LocalPortDesc      string
+	LocalIfIndex       int
+	BridgeIfIndex      int
+
+	RemoteChassisID        string
+	RemoteChassisIDSubtype int
+	RemotePortID           string
+	RemotePortIDSubtype    int
+	RemoteMac              []byte
+	RemoteName             string
+	RemotePortName         string
+	RemoteDescription      string
+
+	// system capabilities by name, bridge, router, stationOnly, ...
+	RemoteCapSupported, RemoteCapEnabled []string
+
+	RemoteManAddrs []ManagementAddress
+
+	// TimeMark is the sysUpTime, in hundredths of a second, when the entry
+	// was last changed and Age the time since. LLDP-MIB does not expose the
+	// time left on the advertised TTL.
+	TimeMark int
+	Age      time.Duration
+
+	// RemIndex is the lldpRemIndex the switch gave the neighbor
+	RemIndex int
+}
+
+// Key returns the key of the neighbor in the map from GetNeighbors.
+func (n *Neighbor) Key() NeighborKey {
+
+	return NeighborKey{n.TimeMark, n.LocalPort, n.RemIndex}
+
+}
+
+// A ManagementAddress is an address a neighbor can be managed at, a row of
+// lldpRemManAddrTable. Family is the IANA address family, 1 for ipv4 and 2
+// for ipv6. IfSubtype and IfID name the interface of the neighbor that has
+// the address, IfSubtype 2 means IfID is an ifIndex and 3 a port number.
+type ManagementAddress struct {
+	Family    int
+	Address   string
+	IfSubtype int
+	IfID      int
+}
+
+// Values of lldpRemChassisIdSubtype, LLDP-MIB LldpChassisIdSubtype.
+const (
+	ChassisIDChassisComponent = 1
+	ChassisIDInterfaceAlias   = 2
+	ChassisIDPortComponent    = 3
+	ChassisIDMacAddress       = 4
+	ChassisIDNetworkAddress   = 5
+	ChassisIDInterfaceName    = 6
+	ChassisIDLocal            = 7
+)
+
+// the bits of LldpSystemCapabilitiesMap, LLDP-MIB and IEEE 802.1AB-2009
+var capabilityNames = []string{
+	"other",
+	"repeater",
+	"bridge",
+	"wlanAccessPoint",
+	"router",
+	"telephone",
+	"docsisCableDevice",
+	"stationOnly",
+	"cVLAN",
+	"sVLAN",
+	"tpmr",
+}
+
+// GetNeighbors fetches the hosts that are directly plugged into the switch,
+// every remote entry of every port. At this time we gather this information
+// by reading the LLDP tables.
+func (c *SwitchControllerSnmp) GetNeighbors() (map[NeighborKey]*Neighbor, error) {
+
+	ports, err := c.GetPortMap()
+	if err != nil {
+		return nil, err
+	}
+	return c.GetNeighborsWith(ports)
+
+}
+
+// GetNeighborsWith is GetNeighbors with the port map of the switch given, so
+// a caller that needs it for other reads as well reads the port tables once.
+func (c *SwitchControllerSnmp) GetNeighborsWith(
+	ports *PortMap) (map[NeighborKey]*Neighbor, error) {
+
+	nbrs := make(map[NeighborKey]*Neighbor)
+
+	// the age of the entries is only known if sysUpTime is
+	uptime, err := getCounter(c.client(), sysUpTimeOid)
+	if err != nil {
+		uptime = -1
+	}
+
+	//lldpRemTable is indexed by time mark, local port and remote index
+	rows, err := c.walkTable(
+		lldpRemPropertyOid(4),
+		lldpRemPropertyOid(5),
+		lldpRemPropertyOid(6),
+		lldpRemPropertyOid(7),
+		lldpRemPropertyOid(8),
+		lldpRemPropertyOid(9),
+		lldpRemPropertyOid(10),
+		lldpRemPropertyOid(11),
+		lldpRemPropertyOid(12),
+	)
+	if err != nil {
+		return nbrs, fmt.Errorf("error reading neighbors %v", err)
+	}
+
+	for _, r := range rows {
+		if len(r.index) != 3 {
+			continue
+		}
+		i := r.index[1]
+		n := &Neighbor{
+			LocalPort:              i,
+			RemoteChassisIDSubtype: r.integer(lldpRemPropertyOid(4)),
+			RemotePortIDSubtype:    r.integer(lldpRemPropertyOid(6)),
+			RemotePortName:         r.str(lldpRemPropertyOid(8)),
+			RemoteName:             r.str(lldpRemPropertyOid(9)),
+			RemoteDescription:      r.str(lldpRemPropertyOid(10)),
+			RemoteCapSupported:     capabilities(r.octets(lldpRemPropertyOid(11))),
+			RemoteCapEnabled:       capabilities(r.octets(lldpRemPropertyOid(12))),
+			TimeMark:               r.index[0],
+			RemIndex:               r.index[2],
+		}
+		chassis := r.octets(lldpRemPropertyOid(5))
+		port := r.octets(lldpRemPropertyOid(7))
+		n.RemoteChassisID = FormatChassisID(n.RemoteChassisIDSubtype, chassis)
+		n.RemotePortID = FormatPortID(n.RemotePortIDSubtype, port)
+		switch {
+		case n.RemotePortIDSubtype == PortIDMacAddress:
+			n.RemoteMac = port
+		case n.RemoteChassisIDSubtype == ChassisIDMacAddress:
+			n.RemoteMac = chassis
+		}
+		if uptime >= 0 && uptime >= n.TimeMark {
+			n.Age = time.Duration(uptime-n.TimeMark) * 10 * time.Millisecond
+		}
+
+		if lp, ok := ports.LldpPort(i); ok {
+			n.LocalPortID = lp.PortID()
+			n.LocalPortIDSubtype = lp.IDSubtype
+			n.LocalPortDesc = lp.Desc
+		}
+		if p, ok := ports.ByLldpPort(i); ok {
+			n.LocalIfIndex = p.IfIndex
+			n.BridgeIfIndex = p.BridgePort
+		}
+		nbrs[n.Key()] = n
+	}
+
+	err = c.getManAddrs(nbrs)
+	if err != nil {
+		return nbrs, err
+	}
+
+	return nbrs, nil
+}
+
+// getManAddrs reads lldpRemManAddrTable and adds the addresses to the
+// neighbors they belong to.
+func (c *SwitchControllerSnmp) getManAddrs(nbrs map[NeighborKey]*Neighbor) error {
+
+	// the table has no readable column that is not also an index, so the
+	// address is taken from the index, time mark, local port, remote index,
+	// address family, address length and the address
+	rows, err := c.walkTable(
+		lldpRemManAddrPropertyOid(3),
+		lldpRemManAddrPropertyOid(4),
+	)
+	if err != nil {
+		return fmt.Errorf("error reading neighbor management addresses %v", err)
+	}
+
+	for _, r := range rows {
+		if len(r.index) < 5 || len(r.index) != 5+r.index[4] {
+			continue
+		}
+		n, ok := nbrs[NeighborKey{r.index[0], r.index[1], r.index[2]}]
+		if !ok {
+			continue
+		}
+		addr := make([]byte, r.index[4])
+		for i := range addr {
+			addr[i] = byte(r.index[5+i])
+		}
+		n.RemoteManAddrs = append(n.RemoteManAddrs, ManagementAddress{
+			Family:    r.index[3],
+			Address:   formatAddress(r.index[3], addr),
+			IfSubtype: r.integer(lldpRemManAddrPropertyOid(3)),
+			IfID:      r.integer(lldpRemManAddrPropertyOid(4)),
+		})
+	}
+
+	return nil
+
+}
+
+// SortNeighbors returns the neighbors ordered by local port and then by
+// remote index.
+func SortNeighbors(nbrs map[NeighborKey]*Neighbor) []*Neighbor {
+
+	result := make([]*Neighbor, 0, len(nbrs))
+	for _, n := range nbrs {
+		result = append(result, n)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].Key(), result[j].Key()
+		if a.LocalPort != b.LocalPort {
+			return a.LocalPort < b.LocalPort
+		}
+		if a.RemIndex != b.RemIndex {
+			return a.RemIndex < b.RemIndex
+		}
+		return a.TimeMark < b.TimeMark
+	})
+	return result
+
+}
+
+// capabilities returns the names of the capabilities set in an
+// LldpSystemCapabilitiesMap.
+func capabilities(b []byte) []string {
+
+	var result []string
+	for i := 0; i < len(b)*8; i++ {
+		if !IsPortSet(i, b) {
+			continue
+		}
+		if i < len(capabilityNames) {
+			result = append(result, capabilityNames[i])
+		} else {
+			result = append(result, fmt.Sprintf("bit%d", i))
+		}
+	}
+	return result
+
+}
+
+// FormatChassisID returns an LLDP chassis id in readable form according to
+// its subtype. Mac addresses are written as 00:11:22:33:44:55, network
+// addresses as ip addresses and the other subtypes, which are text, as is.
+func FormatChassisID(subtype int, id []byte) string {
+
+	switch subtype {
+	case ChassisIDMacAddress:
+		return formatMac(id)
+	case ChassisIDNetworkAddress:
+		return formatNetworkAddress(id)
+	}
+	return string(id)
+
+}
+
+// FormatPortID returns an LLDP port id in readable form according to its
+// subtype. Mac addresses are written as 00:11:22:33:44:55, network addresses
+// as ip addresses and the other subtypes, which are text, as is.
+func FormatPortID(subtype int, id []byte) string {
+
+	switch subtype {
+	case PortIDMacAddress:
+		return formatMac(id)
+	case PortIDNetworkAddress:
+		return formatNetworkAddress(id)
+	}
+	return string(id)
+
+}
+
+// formatMac writes a mac address in colon notation, other lengths in hex.
+func formatMac(b []byte) string {
+
+	if len(b) == 6 {
+		return net.HardwareAddr(b).String()
+	}
+	return fmt.Sprintf("%x", b)
+
+}
+
+// formatNetworkAddress writes an LLDP network address, an IANA address
+// family number followed by the address.
+func formatNetworkAddress(b []byte) string {
+
+	if len(b) == 0 {
+		return ""
+	}
+	return formatAddress(int(b[0]), b[1:])
+
+}
+
+// formatAddress writes an address of the given IANA address family.
+func formatAddress(family int, b []byte) string {
+
+	if family == 1 && len(b) == 4 || family == 2 && len(b) == 16 {
+		return net.IP(b).String()
+	}
+	return fmt.Sprintf("%x", b)
+
+}
@@ -0,0 +1,169 @@
+package snmp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Neighbors added to the fixture. On port 3 a host that sends the mac of
+// its interface as port id, another mac as chassis id and two management
+// addresses, and on port 4 one with a network address for chassis id and no
+// mac at all. The last management address is of no neighbor.
+const lldpNeighbors = `
+.1.3.6.1.2.1.1.3.0 = Timeticks: (600) 0:00:06.00
+.1.0.8802.1.1.2.1.4.1.1.4.100.3.1 = INTEGER: macAddress(4)
+.1.0.8802.1.1.2.1.4.1.1.4.0.4.1 = INTEGER: networkAddress(5)
+.1.0.8802.1.1.2.1.4.1.1.5.100.3.1 = Hex-STRING: 00 11 22 33 00 03
+.1.0.8802.1.1.2.1.4.1.1.5.0.4.1 = Hex-STRING: 01 0A 00 00 04
+.1.0.8802.1.1.2.1.4.1.1.6.100.3.1 = INTEGER: macAddress(3)
+.1.0.8802.1.1.2.1.4.1.1.6.0.4.1 = INTEGER: local(7)
+.1.0.8802.1.1.2.1.4.1.1.7.100.3.1 = Hex-STRING: 00 11 22 33 10 03
+.1.0.8802.1.1.2.1.4.1.1.7.0.4.1 = STRING: "7"
+.1.0.8802.1.1.2.1.4.1.1.8.100.3.1 = STRING: "eth1"
+.1.0.8802.1.1.2.1.4.1.1.9.100.3.1 = STRING: "node3"
+.1.0.8802.1.1.2.1.4.1.1.9.0.4.1 = STRING: "node4"
+.1.0.8802.1.1.2.1.4.1.1.11.100.3.1 = Hex-STRING: 28 00 80
+.1.0.8802.1.1.2.1.4.1.1.12.100.3.1 = Hex-STRING: 08 00
+.1.0.8802.1.1.2.1.4.2.1.3.100.3.1.1.4.10.0.0.3 = INTEGER: ifIndex(2)
+.1.0.8802.1.1.2.1.4.2.1.3.100.3.1.2.16.254.128.0.0.0.0.0.0.0.0.0.0.0.0.0.3 = INTEGER: ifIndex(2)
+.1.0.8802.1.1.2.1.4.2.1.3.0.9.1.1.4.10.0.0.9 = INTEGER: ifIndex(2)
+.1.0.8802.1.1.2.1.4.2.1.4.100.3.1.1.4.10.0.0.3 = INTEGER: 3
+.1.0.8802.1.1.2.1.4.2.1.4.100.3.1.2.16.254.128.0.0.0.0.0.0.0.0.0.0.0.0.0.3 = INTEGER: 3
+.1.0.8802.1.1.2.1.4.2.1.4.0.9.1.1.4.10.0.0.9 = INTEGER: 9
+`
+
+func TestNeighbors(t *testing.T) {
+
+	c := editFixture(t, nil, lldpNeighbors)
+
+	nbrs, err := c.GetNeighbors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nbrs) != 4 {
+		t.Errorf("got %d neighbors, want 4", len(nbrs))
+	}
+
+	n, ok := nbrs[NeighborKey{100, 3, 1}]
+	if !ok {
+		t.Fatal("no neighbor on port 3")
+	}
+	want := &Neighbor{
+		LocalPort:              3,
+		LocalPortID:            "swp3",
+		LocalPortIDSubtype:     PortIDInterfaceName,
+		LocalPortDesc:          "swp3",
+		LocalIfIndex:           1003,
+		BridgeIfIndex:          3,
+		RemoteChassisID:        "00:11:22:33:00:03",
+		RemoteChassisIDSubtype: ChassisIDMacAddress,
+		RemotePortID:           "00:11:22:33:10:03",
+		RemotePortIDSubtype:    PortIDMacAddress,
+		// the port id, not the chassis id
+		RemoteMac:          []byte{0x00, 0x11, 0x22, 0x33, 0x10, 0x03},
+		RemoteName:         "node3",
+		RemotePortName:     "eth1",
+		RemoteCapSupported: []string{"bridge", "router", "bit16"},
+		RemoteCapEnabled:   []string{"router"},
+		RemoteManAddrs: []ManagementAddress{
+			{Family: 1, Address: "10.0.0.3", IfSubtype: 2, IfID: 3},
+			{Family: 2, Address: "fe80::3", IfSubtype: 2, IfID: 3},
+		},
+		TimeMark: 100,
+		Age:      5 * time.Second,
+		RemIndex: 1,
+	}
+	if !reflect.DeepEqual(n, want) {
+		t.Errorf("got %+v\nwant %+v", n, want)
+	}
+
+	n = nbrs[NeighborKey{0, 4, 1}]
+	if n == nil {
+		t.Fatal("no neighbor on port 4")
+	}
+	if n.RemoteChassisID != "10.0.0.4" || n.RemotePortID != "7" {
+		t.Errorf("chassis id %q port id %q", n.RemoteChassisID, n.RemotePortID)
+	}
+	if n.RemoteMac != nil || n.RemoteManAddrs != nil {
+		t.Errorf("mac %x addresses %v, want none", n.RemoteMac, n.RemoteManAddrs)
+	}
+
+	// a mac chassis id is taken when the port id is not a mac
+	n = nbrs[NeighborKey{0, 2, 1}]
+	if n == nil {
+		t.Fatal("no neighbor on port 2")
+	}
+	if !bytes.Equal(n.RemoteMac, []byte{0x00, 0x11, 0x22, 0x33, 0x00, 0x02}) {
+		t.Errorf("port 2 mac %x, want the chassis id", n.RemoteMac)
+	}
+
+}
+
+func TestFormatIDs(t *testing.T) {
+
+	mac := []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	ipv4 := []byte{1, 10, 0, 0, 1}
+	ipv6 := make([]byte, 17)
+	ipv6[0], ipv6[16] = 2, 1
+
+	tests := []struct {
+		format  func(int, []byte) string
+		subtype int
+		id      []byte
+		want    string
+	}{
+		{FormatChassisID, ChassisIDMacAddress, mac, "00:11:22:33:44:55"},
+		{FormatChassisID, ChassisIDNetworkAddress, ipv4, "10.0.0.1"},
+		{FormatChassisID, ChassisIDNetworkAddress, ipv6, "::1"},
+		{FormatChassisID, ChassisIDInterfaceName, []byte("swp1"), "swp1"},
+		{FormatChassisID, ChassisIDLocal, []byte("sw1"), "sw1"},
+		{FormatPortID, PortIDMacAddress, mac, "00:11:22:33:44:55"},
+		{FormatPortID, PortIDNetworkAddress, ipv4, "10.0.0.1"},
+		{FormatPortID, PortIDInterfaceName, []byte("eth0"), "eth0"},
+		{FormatPortID, PortIDLocal, []byte("12"), "12"},
+
+		// the subtypes are numbered differently, 3 is a mac port id but a
+		// port component chassis id, 5 a network address chassis id but an
+		// interface name port id
+		{FormatChassisID, PortIDMacAddress, []byte("Gi1/0/1"), "Gi1/0/1"},
+		{FormatPortID, ChassisIDNetworkAddress, []byte("eth0"), "eth0"},
+
+		// addresses of other lengths or families are written in hex
+		{FormatChassisID, ChassisIDMacAddress, []byte{1, 2, 3}, "010203"},
+		{FormatPortID, PortIDNetworkAddress, []byte{1, 10, 0}, "0a00"},
+		{FormatPortID, PortIDNetworkAddress, []byte{9, 1, 2}, "0102"},
+		{FormatPortID, PortIDNetworkAddress, nil, ""},
+	}
+	for _, x := range tests {
+		got := x.format(x.subtype, x.id)
+		if got != x.want {
+			t.Errorf("%d %x: got %q, want %q", x.subtype, x.id, got, x.want)
+		}
+	}
+
+}
+
+func TestCapabilities(t *testing.T) {
+
+	tests := []struct {
+		bits []byte
+		want []string
+	}{
+		{nil, nil},
+		{[]byte{0x00, 0x00}, nil},
+		{[]byte{0x80}, []string{"other"}},
+		{[]byte{0x28, 0x00}, []string{"bridge", "router"}},
+		{[]byte{0x01, 0x40}, []string{"stationOnly", "sVLAN"}},
+		{[]byte{0x00, 0x30}, []string{"tpmr", "bit11"}},
+		{[]byte{0x00, 0x10, 0x01}, []string{"bit11", "bit23"}},
+	}
+	for _, x := range tests {
+		got := capabilities(x.bits)
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%x: got %v, want %v", x.bits, got, x.want)
+		}
+	}
+
+}
@@ -0,0 +1,342 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Connection Options
+ * ====================================---------------------
+ *
+ * The code here describes how to reach and authenticate to a switch. Both
+ * community based (v2c) and user based (v3 USM) security are supported.
+ * SNMPv1 is not, tables are read with GETBULK which v1 does not have.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/soniah/gosnmp"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SNMPv3 security levels as named in RFC 3411.
+const (
+	NoAuthNoPriv = "noAuthNoPriv"
+	AuthNoPriv   = "authNoPriv"
+	AuthPriv     = "authPriv"
+)
+
+// NoRetries is the value of Options.Retries that sends every request once,
+// as 0 there means the default.
+const NoRetries = -1
+
+// Options holds the parameters used to establish an snmp session with a
+// switch. The zero value of a field means use the default for that field.
+type Options struct {
+	Version   string // snmp version: 2c or 3
+	Community string // community string for v2c
+	Port      uint16
+	Timeout   time.Duration
+	Retries   int // times a request is resent, NoRetries for none
+
+	// MaxRepetitions is the GETBULK max-repetitions used when walking tables,
+	// agents with small buffers need this turned down. Tables are read several
+	// columns per request, so a response holds up to MaxRepetitions cells of
+	// each column.
+	MaxRepetitions int
+
+	// Concurrency is the number of sessions used to walk the columns of a
+	// table in parallel. The default of 1 reads every column over the one
+	// session.
+	Concurrency int
+
+	// Quirks names agent specific behaviors the driver should accommodate.
+	Quirks []string
+
+	// Verify enables reading back every object after it is written and
+	// comparing it with the intended value.
+	Verify bool
+
+	// SNMPv3 user based security model parameters
+	SecurityLevel  string // noAuthNoPriv, authNoPriv or authPriv
+	Username       string
+	AuthProtocol   string // MD5 or SHA
+	AuthPassphrase string
+	PrivProtocol   string // DES or AES
+	PrivPassphrase string
+
+	// SNMPv3 scoped pdu context
+	ContextName     string
+	ContextEngineID string // hex encoded
+
+	// EngineID is the hex encoded authoritative engine id of the agent. When
+	// empty the engine id, boots and time are discovered from the agent before
+	// the first request is sent.
+	EngineID string
+
+	// Logger, when set, is given a line for every request sent to the
+	// switch. It is not read from profiles or flags.
+	Logger *log.Logger
+}
+
+// DefaultOptions returns the options used when none are provided, v2c with
+// the public community.
+func DefaultOptions() *Options {
+
+	return &Options{
+		Version:   "2c",
+		Community: "public",
+		Port:      161,
+		Timeout:   5 * time.Second,
+		Retries:   3,
+	}
+
+}
+
+// BindFlags registers command line flags on fs that populate o.
+func (o *Options) BindFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&o.Version, "version", o.Version, "snmp version (2c, 3)")
+	fs.StringVar(&o.Community, "community", o.Community, "snmp community")
+	fs.Var((*portValue)(&o.Port), "port", "snmp agent udp port")
+	fs.DurationVar(&o.Timeout, "timeout", o.Timeout, "snmp request timeout")
+	fs.Var((*retriesValue)(&o.Retries), "retries",
+		"snmp request retries, 0 for none")
+	fs.IntVar(&o.MaxRepetitions, "max-repetitions", o.MaxRepetitions,
+		"snmp GETBULK max-repetitions")
+	fs.IntVar(&o.Concurrency, "concurrency", o.Concurrency,
+		"number of sessions used to walk table columns in parallel")
+	fs.BoolVar(&o.Verify, "verify", o.Verify,
+		"read back and check every change after it is written")
+	fs.StringVar(&o.SecurityLevel, "level", o.SecurityLevel,
+		"v3 security level (noAuthNoPriv, authNoPriv, authPriv)")
+	fs.StringVar(&o.Username, "user", o.Username, "v3 security name")
+	fs.StringVar(&o.AuthProtocol, "auth", o.AuthProtocol,
+		"v3 authentication protocol (MD5, SHA)")
+	fs.StringVar(&o.AuthPassphrase, "auth-pass", o.AuthPassphrase,
+		"v3 authentication passphrase")
+	fs.StringVar(&o.PrivProtocol, "priv", o.PrivProtocol,
+		"v3 privacy protocol (DES, AES)")
+	fs.StringVar(&o.PrivPassphrase, "priv-pass", o.PrivPassphrase,
+		"v3 privacy passphrase")
+	fs.StringVar(&o.ContextName, "context", o.ContextName, "v3 context name")
+	fs.StringVar(&o.ContextEngineID, "context-engine", o.ContextEngineID,
+		"v3 context engine id (hex)")
+	fs.StringVar(&o.EngineID, "engine-id", o.EngineID,
+		"v3 authoritative engine id (hex), discovered when empty")
+
+}
+
+// Override copies the values of the flags that were explicitly set on fs,
+// which must have been bound with BindFlags, onto o. This is used to let
+// the command line take precedence over a profile.
+func (o *Options) Override(fs *flag.FlagSet) {
+
+	mine := flag.NewFlagSet("", flag.ContinueOnError)
+	o.BindFlags(mine)
+	fs.Visit(func(f *flag.Flag) {
+		if mine.Lookup(f.Name) != nil {
+			mine.Set(f.Name, f.Value.String())
+		}
+	})
+
+}
+
+// HasQuirk returns whether the named quirk is enabled.
+func (o *Options) HasQuirk(name string) bool {
+
+	for _, q := range o.Quirks {
+		if q == name {
+			return true
+		}
+	}
+	return false
+
+}
+
+// session creates a new, unconnected, gosnmp session for target according to
+// the options.
+func (o *Options) session(target string) (*gosnmp.GoSNMP, error) {
+
+	d := DefaultOptions()
+	s := &gosnmp.GoSNMP{
+		Target:    target,
+		Port:      d.Port,
+		Community: d.Community,
+		Timeout:   d.Timeout,
+		Retries:   d.Retries,
+		MaxOids:   gosnmp.Default.MaxOids,
+	}
+	if o.Port != 0 {
+		s.Port = o.Port
+	}
+	if o.Community != "" {
+		s.Community = o.Community
+	}
+	if o.Timeout != 0 {
+		s.Timeout = o.Timeout
+	}
+	switch {
+	case o.Retries == NoRetries:
+		s.Retries = 0
+	case o.Retries > 0:
+		s.Retries = o.Retries
+	case o.Retries < 0:
+		return nil, fmt.Errorf("retries %d out of range", o.Retries)
+	}
+	if o.MaxRepetitions > 0 {
+		if o.MaxRepetitions > math.MaxUint8 {
+			return nil, fmt.Errorf(
+				"max-repetitions %d out of range", o.MaxRepetitions)
+		}
+		s.MaxRepetitions = uint8(o.MaxRepetitions)
+	}
+
+	switch o.Version {
+	case "1":
+		return nil, fmt.Errorf("snmp version 1 is not supported, tables are " +
+			"read with GETBULK which it does not have, use 2c or 3")
+	case "", "2", "2c":
+		s.Version = gosnmp.Version2c
+	case "3":
+		s.Version = gosnmp.Version3
+		err := o.usm(s)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported snmp version %q", o.Version)
+	}
+
+	return s, nil
+
+}
+
+// usm configures the v3 user based security model parameters of s.
+func (o *Options) usm(s *gosnmp.GoSNMP) error {
+
+	if o.Username == "" {
+		return fmt.Errorf("snmpv3 requires a user name")
+	}
+
+	params := &gosnmp.UsmSecurityParameters{
+		UserName:               o.Username,
+		AuthenticationProtocol: gosnmp.NoAuth,
+		PrivacyProtocol:        gosnmp.NoPriv,
+	}
+
+	level := o.SecurityLevel
+	if level == "" {
+		level = NoAuthNoPriv
+		if o.AuthPassphrase != "" {
+			level = AuthNoPriv
+		}
+		if o.PrivPassphrase != "" {
+			level = AuthPriv
+		}
+	}
+
+	switch strings.ToLower(level) {
+	case strings.ToLower(NoAuthNoPriv):
+		s.MsgFlags = gosnmp.NoAuthNoPriv
+	case strings.ToLower(AuthNoPriv):
+		s.MsgFlags = gosnmp.AuthNoPriv
+	case strings.ToLower(AuthPriv):
+		s.MsgFlags = gosnmp.AuthPriv
+	default:
+		return fmt.Errorf("unknown snmpv3 security level %q", level)
+	}
+
+	if s.MsgFlags != gosnmp.NoAuthNoPriv {
+		switch strings.ToUpper(o.AuthProtocol) {
+		case "MD5":
+			params.AuthenticationProtocol = gosnmp.MD5
+		case "", "SHA":
+			params.AuthenticationProtocol = gosnmp.SHA
+		default:
+			return fmt.Errorf("unsupported snmpv3 auth protocol %q", o.AuthProtocol)
+		}
+		if o.AuthPassphrase == "" {
+			return fmt.Errorf("snmpv3 %s requires an auth passphrase", level)
+		}
+		params.AuthenticationPassphrase = o.AuthPassphrase
+	}
+
+	if s.MsgFlags == gosnmp.AuthPriv {
+		switch strings.ToUpper(o.PrivProtocol) {
+		case "DES":
+			params.PrivacyProtocol = gosnmp.DES
+		case "", "AES":
+			params.PrivacyProtocol = gosnmp.AES
+		default:
+			return fmt.Errorf("unsupported snmpv3 privacy protocol %q", o.PrivProtocol)
+		}
+		if o.PrivPassphrase == "" {
+			return fmt.Errorf("snmpv3 %s requires a privacy passphrase", level)
+		}
+		params.PrivacyPassphrase = o.PrivPassphrase
+	}
+
+	if o.EngineID != "" {
+		id, err := hex.DecodeString(o.EngineID)
+		if err != nil {
+			return fmt.Errorf("bad snmpv3 engine id %q: %v", o.EngineID, err)
+		}
+		params.AuthoritativeEngineID = string(id)
+	}
+
+	if o.ContextEngineID != "" {
+		id, err := hex.DecodeString(o.ContextEngineID)
+		if err != nil {
+			return fmt.Errorf(
+				"bad snmpv3 context engine id %q: %v", o.ContextEngineID, err)
+		}
+		s.ContextEngineID = string(id)
+	}
+	s.ContextName = o.ContextName
+
+	s.SecurityModel = gosnmp.UserSecurityModel
+	s.SecurityParameters = params
+	return nil
+
+}
+
+// portValue is a flag.Value for udp port numbers
+type portValue uint16
+
+func (p *portValue) String() string { return strconv.Itoa(int(*p)) }
+
+func (p *portValue) Set(s string) error {
+	v, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", s)
+	}
+	*p = portValue(v)
+	return nil
+}
+
+// retriesValue is a flag.Value for Options.Retries, it takes 0 to mean no
+// retries rather than the default.
+type retriesValue int
+
+func (r *retriesValue) String() string {
+	if *r == NoRetries {
+		return "0"
+	}
+	return strconv.Itoa(int(*r))
+}
+
+func (r *retriesValue) Set(s string) error {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid retries %q", s)
+	}
+	if v == 0 {
+		v = NoRetries
+	}
+	*r = retriesValue(v)
+	return nil
+}
@@ -0,0 +1,123 @@
+package snmp
+
+import (
+	"flag"
+	"github.com/soniah/gosnmp"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const retriesProfiles = `
+defaults:
+  retries: 2
+switches:
+  leaf0:
+    retries: 0
+  leaf1:
+    address: 10.47.1.5
+`
+
+// loadProfiles loads profiles from the text of a profiles file.
+func loadProfiles(t *testing.T, text string) *Profiles {
+
+	f, err := ioutil.TempFile("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(text)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadProfiles(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+
+}
+
+// sessionRetries returns the retries of the session opts make.
+func sessionRetries(t *testing.T, opts *Options) int {
+
+	s, err := opts.session("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Retries
+
+}
+
+// A retries of 0 in a profile or on the command line sends every request
+// once, rather than falling back to the default.
+func TestNoRetries(t *testing.T) {
+
+	p := loadProfiles(t, retriesProfiles)
+
+	_, _, opts, err := p.Resolve("leaf0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := sessionRetries(t, opts); n != 0 {
+		t.Errorf("profile retries 0: session retries %d, want 0", n)
+	}
+
+	_, _, opts, err = p.Resolve("leaf1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := sessionRetries(t, opts); n != 2 {
+		t.Errorf("default retries 2: session retries %d, want 2", n)
+	}
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	DefaultOptions().BindFlags(fs)
+	err = fs.Parse([]string{"-retries", "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, opts, err = p.ResolveFlags("leaf1", "", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := sessionRetries(t, opts); n != 0 {
+		t.Errorf("-retries 0: session retries %d, want 0", n)
+	}
+
+	if n := sessionRetries(t, &Options{}); n != DefaultOptions().Retries {
+		t.Errorf("zero options: session retries %d, want the default", n)
+	}
+
+}
+
+// Tables are read with GETBULK, so SNMPv1 is refused up front.
+func TestVersion1Rejected(t *testing.T) {
+
+	_, err := (&Options{Version: "1"}).session("localhost")
+	if err == nil {
+		t.Error("version 1: no error")
+	}
+
+}
+
+// NewGoSNMP refuses the versions it cannot set up, rather than falling back
+// to v2c.
+func TestNewGoSNMPVersion(t *testing.T) {
+
+	for _, v := range []gosnmp.SnmpVersion{gosnmp.Version1, gosnmp.Version3} {
+		s, err := NewGoSNMP("127.0.0.1", "public", v, 1)
+		if err == nil {
+			s.Conn.Close()
+			t.Errorf("version %v: no error", v)
+		}
+	}
+
+	s, err := NewGoSNMP("127.0.0.1", "public", gosnmp.Version2c, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Conn.Close()
+
+}
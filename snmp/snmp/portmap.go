@@ -0,0 +1,391 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Port Map
+ * ====================================-----------
+ *
+ * A switch port goes by several names. The Q-BRIDGE tables number it by
+ * bridge port (dot1dBasePort), the interface tables by ifIndex, LLDP by its
+ * own local port number, and people by its name, swp12 or Gi1/0/12. The code
+ * here reads the tables that relate them once and resolves a port given in
+ * any of these forms. A port is written as
+ *
+ *	12            bridge port 12
+ *	bridge:12     bridge port 12
+ *	ifindex:1012  the interface with ifIndex 1012
+ *	lldp:12       LLDP local port 12
+ *	swp12         the interface with that ifName, ifDescr or ifAlias
+ *
+ * and lists of them as 1-8,17 or swp1-swp16, see ranges.go.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Port is an interface of a switch under all the names it goes by. The
+// numbers that do not apply are 0, BridgePort for interfaces that are not
+// bridge ports and LldpPort for ports LLDP does not run on.
+type Port struct {
+	BridgePort int
+	IfIndex    int
+	LldpPort   int
+	Name       string // ifName
+	Descr      string // ifDescr
+	Alias      string // ifAlias
+}
+
+// A PortMap resolves between the names of the ports of a switch.
+type PortMap struct {
+	ports    []Port
+	byBridge map[int]int
+	byIf     map[int]int
+	byLldp   map[int]int
+	lldp     map[int]LldpPort
+}
+
+// GetPortMap reads the interface, bridge port and LLDP local port tables of
+// the switch and relates them.
+func (c *SwitchControllerSnmp) GetPortMap() (*PortMap, error) {
+
+	rows, err := c.walkTable(
+		interfacePropertyOid(2),
+		interfacePropertyOid(6),
+		interfaceNameOid,
+		interfaceAliasOid,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error reading interfaces: %v", err)
+	}
+
+	m := &PortMap{
+		byBridge: make(map[int]int),
+		byIf:     make(map[int]int),
+		byLldp:   make(map[int]int),
+		lldp:     make(map[int]LldpPort),
+	}
+	var macs [][]byte
+	for _, r := range rows {
+		m.byIf[r.last()] = len(m.ports)
+		m.ports = append(m.ports, Port{
+			IfIndex: r.last(),
+			Descr:   r.str(interfacePropertyOid(2)),
+			Name:    r.str(interfaceNameOid),
+			Alias:   r.str(interfaceAliasOid),
+		})
+		macs = append(macs, r.octets(interfacePropertyOid(6)))
+	}
+
+	rows, err = c.walkTable(interfaceBridgeIndexOid)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bridge ports: %v", err)
+	}
+	for _, r := range rows {
+		i, ok := m.byIf[r.integer(interfaceBridgeIndexOid)]
+		if ok {
+			m.ports[i].BridgePort = r.last()
+			m.byBridge[r.last()] = i
+		}
+	}
+
+	rows, err = c.walkTable(
+		lldpLocPortPropertyOid(2),
+		lldpLocPortPropertyOid(3),
+		lldpLocPortPropertyOid(4),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error reading lldp local ports: %v", err)
+	}
+	for _, r := range rows {
+		lp := LldpPort{
+			Num:       r.last(),
+			IDSubtype: r.integer(lldpLocPortPropertyOid(2)),
+			ID:        r.octets(lldpLocPortPropertyOid(3)),
+			Desc:      r.str(lldpLocPortPropertyOid(4)),
+		}
+		i, ok := m.lldpInterface(lp, macs)
+		if ok && m.ports[i].LldpPort == 0 {
+			m.ports[i].LldpPort = lp.Num
+			m.byLldp[lp.Num] = i
+			lp.IfIndex = m.ports[i].IfIndex
+		}
+		m.lldp[lp.Num] = lp
+	}
+
+	return m, nil
+
+}
+
+// lldpInterface finds the interface an LLDP local port is on from its port
+// id, or failing that its description. As a last resort the port number is
+// taken as a bridge port, which LLDP-MIB requires of bridges.
+func (m *PortMap) lldpInterface(lp LldpPort, macs [][]byte) (int, bool) {
+
+	name := string(lp.ID)
+	switch lp.IDSubtype {
+	case PortIDInterfaceName:
+		if i, ok := m.named(name, func(p *Port) string { return p.Name }); ok {
+			return i, true
+		}
+		if i, ok := m.named(name, func(p *Port) string { return p.Descr }); ok {
+			return i, true
+		}
+	case PortIDInterfaceAlias:
+		if i, ok := m.named(name, func(p *Port) string { return p.Alias }); ok {
+			return i, true
+		}
+	case PortIDMacAddress:
+		var found []int
+		for i, mac := range macs {
+			if len(mac) > 0 && bytes.Equal(mac, lp.ID) {
+				found = append(found, i)
+			}
+		}
+		// switches often give every port the same address
+		if len(found) == 1 {
+			return found[0], true
+		}
+	case PortIDLocal:
+		if x, err := strconv.Atoi(name); err == nil {
+			if i, ok := m.byIf[x]; ok {
+				return i, true
+			}
+		}
+		if i, ok := m.lookupName(name); ok {
+			return i, true
+		}
+	}
+
+	if lp.Desc != "" {
+		if i, ok := m.lookupName(lp.Desc); ok {
+			return i, true
+		}
+	}
+
+	i, ok := m.byBridge[lp.Num]
+	return i, ok
+
+}
+
+// Ports returns every port in ifIndex order.
+func (m *PortMap) Ports() []Port {
+
+	return append([]Port(nil), m.ports...)
+
+}
+
+// BridgePorts returns the bridge ports in bridge port order.
+func (m *PortMap) BridgePorts() []Port {
+
+	var result []Port
+	for _, p := range m.ports {
+		if p.BridgePort != 0 {
+			result = append(result, p)
+		}
+	}
+	sortPorts(result)
+	return result
+
+}
+
+// ByBridgePort returns the port with the given dot1dBasePort.
+func (m *PortMap) ByBridgePort(n int) (Port, bool) {
+
+	return m.get(m.byBridge, n)
+
+}
+
+// ByIfIndex returns the port with the given ifIndex.
+func (m *PortMap) ByIfIndex(n int) (Port, bool) {
+
+	return m.get(m.byIf, n)
+
+}
+
+// ByLldpPort returns the port with the given LLDP local port number.
+func (m *PortMap) ByLldpPort(n int) (Port, bool) {
+
+	return m.get(m.byLldp, n)
+
+}
+
+// LldpPort returns the row of lldpLocPortTable for LLDP local port n.
+func (m *PortMap) LldpPort(n int) (LldpPort, bool) {
+
+	lp, ok := m.lldp[n]
+	return lp, ok
+
+}
+
+// ByName returns the port with the given ifName, or failing that ifDescr
+// or ifAlias. Names are matched exactly, then ignoring case.
+func (m *PortMap) ByName(name string) (Port, bool) {
+
+	i, ok := m.lookupName(name)
+	if !ok {
+		return Port{}, false
+	}
+	return m.ports[i], true
+
+}
+
+// Resolve returns the port named by s in any of the forms described at the
+// top of this file.
+func (m *PortMap) Resolve(s string) (Port, error) {
+
+	var index map[int]int
+	var what, value string
+	switch {
+	case strings.HasPrefix(s, "bridge:"):
+		index, what, value = m.byBridge, "bridge port", s[len("bridge:"):]
+	case strings.HasPrefix(s, "ifindex:"):
+		index, what, value = m.byIf, "ifIndex", s[len("ifindex:"):]
+	case strings.HasPrefix(s, "lldp:"):
+		index, what, value = m.byLldp, "lldp port", s[len("lldp:"):]
+	default:
+		if _, err := strconv.Atoi(s); err == nil {
+			index, what, value = m.byBridge, "bridge port", s
+			break
+		}
+		p, ok := m.ByName(s)
+		if !ok {
+			return Port{}, fmt.Errorf("no port named %q", s)
+		}
+		return p, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return Port{}, fmt.Errorf("invalid %s %q", what, value)
+	}
+	p, ok := m.get(index, n)
+	if !ok {
+		return Port{}, fmt.Errorf("no %s %d", what, n)
+	}
+	return p, nil
+
+}
+
+// ResolveBridgePorts resolves each of the provided ports to its bridge port.
+func (m *PortMap) ResolveBridgePorts(ports ...string) ([]int, error) {
+
+	result := make([]int, 0, len(ports))
+	for _, s := range ports {
+		p, err := m.Resolve(s)
+		if err != nil {
+			return nil, err
+		}
+		if p.BridgePort == 0 {
+			return nil, fmt.Errorf("port %s is not a bridge port", s)
+		}
+		result = append(result, p.BridgePort)
+	}
+	return result, nil
+
+}
+
+// ResolvePortList resolves lists of ports, 1-8,17 or swp1-swp16 for example,
+// to their bridge ports, in the order given and without duplicates. An item
+// of a list that is the name of a port is taken as that port, not as a
+// range, see ParsePortList.
+func (m *PortMap) ResolvePortList(lists ...string) ([]int, error) {
+
+	var result []int
+	seen := make(map[int]bool)
+	for _, list := range lists {
+		for _, item := range strings.Split(list, ",") {
+			var names []string
+			if _, ok := m.lookupName(item); ok {
+				names = []string{item}
+			} else {
+				var err error
+				names, err = expandItem(item)
+				if err != nil {
+					return nil, err
+				}
+			}
+			ports, err := m.ResolveBridgePorts(names...)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range ports {
+				if !seen[p] {
+					seen[p] = true
+					result = append(result, p)
+				}
+			}
+		}
+	}
+	return result, nil
+
+}
+
+func (m *PortMap) get(index map[int]int, n int) (Port, bool) {
+
+	i, ok := index[n]
+	if !ok {
+		return Port{}, false
+	}
+	return m.ports[i], true
+
+}
+
+// lookupName finds a port by ifName, ifDescr and then ifAlias.
+func (m *PortMap) lookupName(name string) (int, bool) {
+
+	fields := []func(p *Port) string{
+		func(p *Port) string { return p.Name },
+		func(p *Port) string { return p.Descr },
+		func(p *Port) string { return p.Alias },
+	}
+	for _, f := range fields {
+		if i, ok := m.named(name, f); ok {
+			return i, true
+		}
+	}
+	return -1, false
+
+}
+
+// named finds the one port whose field f is name, matching exactly and then
+// ignoring case. A name shared by several ports matches none of them.
+func (m *PortMap) named(name string, f func(p *Port) string) (int, bool) {
+
+	if name == "" {
+		return -1, false
+	}
+	for _, eq := range []func(a, b string) bool{
+		func(a, b string) bool { return a == b },
+		strings.EqualFold,
+	} {
+		found := -1
+		for i := range m.ports {
+			if !eq(f(&m.ports[i]), name) {
+				continue
+			}
+			if found >= 0 {
+				return -1, false
+			}
+			found = i
+		}
+		if found >= 0 {
+			return found, true
+		}
+	}
+	return -1, false
+
+}
+
+// sortPorts sorts ports by bridge port.
+func sortPorts(ports []Port) {
+
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i].BridgePort < ports[j].BridgePort
+	})
+
+}
@@ -0,0 +1,138 @@
+package snmp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func loadPortMap(t *testing.T) *PortMap {
+
+	c, _ := loadFixture(t, nil)
+	m, err := c.GetPortMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+
+}
+
+func TestResolve(t *testing.T) {
+
+	m := loadPortMap(t)
+
+	tests := []struct {
+		port   string
+		bridge int // 0 for an error
+	}{
+		{"3", 3},
+		{"bridge:3", 3},
+		{"ifindex:1005", 5},
+		{"lldp:2", 2},
+		{"swp7", 7},
+		{"SWP7", 7},
+		{"node4", 4}, // ifAlias
+
+		// unknown ports
+		{"9", 0},
+		{"bridge:9", 0},
+		{"ifindex:5", 0},
+		{"lldp:9", 0},
+		{"swp9", 0},
+		{"", 0},
+		// ports 7 and 8 share the alias
+		{"uplink", 0},
+		// not numbers
+		{"bridge:x", 0},
+		{"ifindex:", 0},
+	}
+	for _, x := range tests {
+		p, err := m.Resolve(x.port)
+		if x.bridge == 0 {
+			if err == nil {
+				t.Errorf("%q: got %+v, want an error", x.port, p)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", x.port, err)
+			continue
+		}
+		if p.BridgePort != x.bridge || p.IfIndex != 1000+x.bridge {
+			t.Errorf("%q: got %+v, want bridge port %d", x.port, p, x.bridge)
+		}
+	}
+
+}
+
+func TestResolveBridgePorts(t *testing.T) {
+
+	m := loadPortMap(t)
+
+	got, err := m.ResolveBridgePorts("swp1", "ifindex:1008", "2", "lldp:5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []int{1, 8, 2, 5}) {
+		t.Errorf("got %v, want [1 8 2 5]", got)
+	}
+
+	_, err = m.ResolveBridgePorts("swp1", "swp9")
+	if err == nil {
+		t.Errorf("swp9: no error")
+	}
+
+	// an interface that is no bridge port
+	m = &PortMap{
+		ports: []Port{
+			{IfIndex: 1, Name: "lo"},
+			{BridgePort: 1, IfIndex: 2, Name: "swp1"},
+		},
+		byBridge: map[int]int{1: 1},
+		byIf:     map[int]int{1: 0, 2: 1},
+	}
+	_, err = m.ResolveBridgePorts("lo")
+	if err == nil {
+		t.Errorf("lo: no error")
+	}
+
+}
+
+func TestResolvePortList(t *testing.T) {
+
+	m := loadPortMap(t)
+
+	tests := []struct {
+		lists []string
+		want  []int // nil for an error
+	}{
+		{[]string{"1-3"}, []int{1, 2, 3}},
+		{[]string{"swp1-swp3,8"}, []int{1, 2, 3, 8}},
+		{[]string{"swp6-8", "node2"}, []int{6, 7, 8, 2}},
+		{[]string{"ifindex:1004-1005"}, []int{4, 5}},
+		{[]string{"bridge:7,lldp:1"}, []int{7, 1}},
+		// duplicates are dropped across the lists
+		{[]string{"1-4", "swp3-swp5"}, []int{1, 2, 3, 4, 5}},
+
+		{[]string{"swp7-swp9"}, nil},
+		{[]string{"3-1"}, nil},
+		{[]string{"1,,2"}, nil},
+		{[]string{"1", "uplink"}, nil},
+	}
+	for _, x := range tests {
+		got, err := m.ResolvePortList(x.lists...)
+		if x.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", x.lists, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", x.lists, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%q: got %v, want %v", x.lists, got, x.want)
+		}
+	}
+
+}
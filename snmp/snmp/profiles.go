@@ -0,0 +1,374 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Connection Profiles
+ * ====================================----------------------
+ *
+ * The code here loads switch connection profiles from a YAML file so that
+ * credentials and agent tuning do not have to be given on every invocation.
+ * A profiles file looks like
+ *
+ *	defaults:
+ *	  version: 2c
+ *	  community: env:DETER_SNMP_COMMUNITY
+ *
+ *	switches:
+ *	  leaf1:
+ *	    address: 10.47.1.5
+ *	    version: 3
+ *	    level: authPriv
+ *	    user: deter
+ *	    auth: SHA
+ *	    auth-pass: file:/etc/deter/leaf1.auth
+ *	    priv: AES
+ *	    priv-pass: file:/etc/deter/leaf1.priv
+ *	    timeout: 10s
+ *	    max-repetitions: 10
+ *	    concurrency: 2
+ *	    quirks: [no-multi-set]
+ *	    verify: true
+ *
+ * Switches are looked up by name and then by address. The fields of a switch
+ * override those of the defaults, except quirks, which add to the default
+ * ones. A quirk written -name, as in quirks: [-no-multi-set], removes a
+ * default quirk instead. Secret values may be given inline, as env:VARIABLE
+ * or as file:/path/to/secret.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProfilesEnv is the environment variable consulted for the location of the
+// profiles file when one is not given explicitly.
+const ProfilesEnv = "DETER_SWITCH_PROFILES"
+
+// DefaultProfilesPath is the profiles file used when neither a path nor
+// ProfilesEnv is provided.
+const DefaultProfilesPath = "/etc/deter/switches.yml"
+
+// A Profile holds the connection parameters for a switch as they appear in
+// a profiles file.
+type Profile struct {
+	Address        string   `yaml:"address"`
+	Driver         string   `yaml:"driver"`
+	Version        string   `yaml:"version"`
+	Community      string   `yaml:"community"`
+	Port           uint16   `yaml:"port"`
+	Timeout        string   `yaml:"timeout"`
+	Retries        *int     `yaml:"retries"` // nil when not given, 0 is none
+	MaxRepetitions int      `yaml:"max-repetitions"`
+	Concurrency    int      `yaml:"concurrency"`
+	Quirks         []string `yaml:"quirks"` // -name removes a default quirk
+	Verify         *bool    `yaml:"verify"` // nil when not given
+
+	SecurityLevel   string `yaml:"level"`
+	Username        string `yaml:"user"`
+	AuthProtocol    string `yaml:"auth"`
+	AuthPassphrase  string `yaml:"auth-pass"`
+	PrivProtocol    string `yaml:"priv"`
+	PrivPassphrase  string `yaml:"priv-pass"`
+	ContextName     string `yaml:"context"`
+	ContextEngineID string `yaml:"context-engine"`
+	EngineID        string `yaml:"engine-id"`
+}
+
+// Profiles is the contents of a profiles file.
+type Profiles struct {
+	Defaults Profile             `yaml:"defaults"`
+	Switches map[string]*Profile `yaml:"switches"`
+}
+
+// LoadProfiles reads the profiles file at path. An empty path means the
+// file named by ProfilesEnv or, failing that, DefaultProfilesPath. A missing
+// default file is not an error and yields an empty set of profiles.
+func LoadProfiles(path string) (*Profiles, error) {
+
+	explicit := true
+	if path == "" {
+		path = os.Getenv(ProfilesEnv)
+	}
+	if path == "" {
+		path = DefaultProfilesPath
+		explicit = false
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return &Profiles{}, nil
+		}
+		return nil, fmt.Errorf("failed to read profiles: %v", err)
+	}
+
+	p := &Profiles{}
+	err = yaml.UnmarshalStrict(buf, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse profiles %s: %v", path, err)
+	}
+
+	return p, nil
+
+}
+
+// Lookup finds the profile for host, which may be a switch name or address.
+// The returned profile has the defaults applied. When no profile matches,
+// the defaults are returned with host as the address.
+func (p *Profiles) Lookup(host string) *Profile {
+
+	sw, ok := p.Switches[host]
+	if !ok {
+		for _, x := range p.Switches {
+			if x.Address == host {
+				sw = x
+				break
+			}
+		}
+	}
+
+	result := p.Defaults
+	result.Quirks = append([]string(nil), p.Defaults.Quirks...)
+	if sw != nil {
+		result.merge(sw)
+	}
+	if result.Address == "" {
+		result.Address = host
+	}
+
+	return &result
+
+}
+
+// Names returns the sorted names of the switches in the profiles.
+func (p *Profiles) Names() []string {
+
+	var names []string
+	for name := range p.Switches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+
+}
+
+// Resolve looks up host and returns the address, driver and connection
+// options to use for it.
+func (p *Profiles) Resolve(host string) (string, string, *Options, error) {
+
+	pr := p.Lookup(host)
+	opts, err := pr.Options()
+	if err != nil {
+		return "", "", nil, fmt.Errorf("profile %s: %v", host, err)
+	}
+	return pr.Address, pr.Driver, opts, nil
+
+}
+
+// ResolveFlags resolves host as Resolve does and then lets the connection
+// flags explicitly set on fs, which must have been bound with BindFlags, take
+// precedence over the profile. A non-empty driver takes precedence over the
+// driver of the profile, and DefaultDriver is returned when neither names one.
+func (p *Profiles) ResolveFlags(host, driver string, fs *flag.FlagSet) (
+	string, string, *Options, error) {
+
+	address, d, opts, err := p.Resolve(host)
+	if err != nil {
+		return "", "", nil, err
+	}
+	opts.Override(fs)
+	if driver != "" {
+		d = driver
+	}
+	if d == "" {
+		d = DefaultDriver
+	}
+	return address, d, opts, nil
+
+}
+
+// ResolveHost resolves host through the default profiles file, see
+// LoadProfiles. The results are the arguments of NewSwitchController.
+func ResolveHost(host string) (string, string, *Options, error) {
+
+	p, err := LoadProfiles("")
+	if err != nil {
+		return "", "", nil, err
+	}
+	return p.Resolve(host)
+
+}
+
+// Options converts a profile into connection options, resolving any secrets
+// that are held in the environment or in files.
+func (pr *Profile) Options() (*Options, error) {
+
+	o := DefaultOptions()
+
+	if pr.Version != "" {
+		o.Version = pr.Version
+	}
+	if pr.Port != 0 {
+		o.Port = pr.Port
+	}
+	if pr.Timeout != "" {
+		t, err := parseTimeout(pr.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		o.Timeout = t
+	}
+	if pr.Retries != nil {
+		switch {
+		case *pr.Retries < 0:
+			return nil, fmt.Errorf("invalid retries %d", *pr.Retries)
+		case *pr.Retries == 0:
+			o.Retries = NoRetries
+		default:
+			o.Retries = *pr.Retries
+		}
+	}
+	o.MaxRepetitions = pr.MaxRepetitions
+	o.Concurrency = pr.Concurrency
+	for _, q := range pr.Quirks {
+		if !strings.HasPrefix(q, "-") {
+			o.Quirks = append(o.Quirks, q)
+		}
+	}
+	if pr.Verify != nil {
+		o.Verify = *pr.Verify
+	}
+
+	o.SecurityLevel = pr.SecurityLevel
+	o.Username = pr.Username
+	o.AuthProtocol = pr.AuthProtocol
+	o.PrivProtocol = pr.PrivProtocol
+	o.ContextName = pr.ContextName
+	o.ContextEngineID = pr.ContextEngineID
+	o.EngineID = pr.EngineID
+
+	secrets := []struct {
+		value string
+		dst   *string
+	}{
+		{pr.Community, &o.Community},
+		{pr.AuthPassphrase, &o.AuthPassphrase},
+		{pr.PrivPassphrase, &o.PrivPassphrase},
+	}
+	for _, s := range secrets {
+		if s.value == "" {
+			continue
+		}
+		v, err := resolveSecret(s.value)
+		if err != nil {
+			return nil, err
+		}
+		*s.dst = v
+	}
+
+	return o, nil
+
+}
+
+// merge overlays the fields that are set in x onto pr.
+func (pr *Profile) merge(x *Profile) {
+
+	str := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+
+	str(&pr.Address, x.Address)
+	str(&pr.Driver, x.Driver)
+	str(&pr.Version, x.Version)
+	str(&pr.Community, x.Community)
+	str(&pr.Timeout, x.Timeout)
+	str(&pr.SecurityLevel, x.SecurityLevel)
+	str(&pr.Username, x.Username)
+	str(&pr.AuthProtocol, x.AuthProtocol)
+	str(&pr.AuthPassphrase, x.AuthPassphrase)
+	str(&pr.PrivProtocol, x.PrivProtocol)
+	str(&pr.PrivPassphrase, x.PrivPassphrase)
+	str(&pr.ContextName, x.ContextName)
+	str(&pr.ContextEngineID, x.ContextEngineID)
+	str(&pr.EngineID, x.EngineID)
+
+	if x.Port != 0 {
+		pr.Port = x.Port
+	}
+	if x.Retries != nil {
+		pr.Retries = x.Retries
+	}
+	if x.MaxRepetitions != 0 {
+		pr.MaxRepetitions = x.MaxRepetitions
+	}
+	if x.Concurrency != 0 {
+		pr.Concurrency = x.Concurrency
+	}
+	if x.Verify != nil {
+		pr.Verify = x.Verify
+	}
+	for _, q := range x.Quirks {
+		name := strings.TrimPrefix(q, "-")
+		var kept []string
+		for _, have := range pr.Quirks {
+			if have != name {
+				kept = append(kept, have)
+			}
+		}
+		pr.Quirks = kept
+		if name == q {
+			pr.Quirks = append(pr.Quirks, q)
+		}
+	}
+
+}
+
+// resolveSecret returns the value of a secret which is either inline or a
+// reference of the form env:VARIABLE or file:/path.
+func resolveSecret(s string) (string, error) {
+
+	switch {
+	case strings.HasPrefix(s, "env:"):
+		name := strings.TrimPrefix(s, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret environment variable %s not set", name)
+		}
+		return v, nil
+
+	case strings.HasPrefix(s, "file:"):
+		buf, err := ioutil.ReadFile(strings.TrimPrefix(s, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret: %v", err)
+		}
+		return strings.TrimRight(string(buf), "\r\n"), nil
+	}
+
+	return s, nil
+
+}
+
+// parseTimeout accepts either a go duration (5s, 1500ms) or a plain number
+// of seconds.
+func parseTimeout(s string) (time.Duration, error) {
+
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	t, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q", s)
+	}
+	return t, nil
+
+}
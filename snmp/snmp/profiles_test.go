@@ -0,0 +1,182 @@
+package snmp
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const mergeProfiles = `
+defaults:
+  community: public
+  timeout: 3s
+  quirks: [no-multi-set, slow-bulk]
+  verify: true
+switches:
+  leaf0:
+    address: 10.47.1.5
+    community: leaf0
+    quirks: [-no-multi-set, short-portlists]
+    verify: false
+  leaf1:
+    address: 10.47.1.6
+    timeout: 10
+    max-repetitions: 10
+`
+
+func TestLookup(t *testing.T) {
+
+	p := loadProfiles(t, mergeProfiles)
+
+	tests := []struct {
+		host    string
+		address string
+		want    Options
+	}{
+		// the switch overrides the defaults, removes a default quirk and
+		// turns verify off
+		{"leaf0", "10.47.1.5", Options{
+			Community: "leaf0",
+			Timeout:   3 * time.Second,
+			Quirks:    []string{"slow-bulk", "short-portlists"},
+			Verify:    false,
+		}},
+		// by address, the defaults it does not override stay
+		{"10.47.1.6", "10.47.1.6", Options{
+			Community:      "public",
+			Timeout:        10 * time.Second,
+			MaxRepetitions: 10,
+			Quirks:         []string{"no-multi-set", "slow-bulk"},
+			Verify:         true,
+		}},
+		// an unknown switch gets the defaults at its own address
+		{"leaf9", "leaf9", Options{
+			Community: "public",
+			Timeout:   3 * time.Second,
+			Quirks:    []string{"no-multi-set", "slow-bulk"},
+			Verify:    true,
+		}},
+	}
+	for _, x := range tests {
+		address, _, opts, err := p.Resolve(x.host)
+		if err != nil {
+			t.Errorf("%s: %v", x.host, err)
+			continue
+		}
+		if address != x.address {
+			t.Errorf("%s: address %s, want %s", x.host, address, x.address)
+		}
+		if opts.Community != x.want.Community ||
+			opts.Timeout != x.want.Timeout ||
+			opts.MaxRepetitions != x.want.MaxRepetitions ||
+			opts.Verify != x.want.Verify ||
+			!reflect.DeepEqual(opts.Quirks, x.want.Quirks) {
+			t.Errorf("%s: got %+v, want %+v", x.host, opts, x.want)
+		}
+	}
+
+	// looking up a switch leaves the defaults as they were
+	defaults := []string{"no-multi-set", "slow-bulk"}
+	if !reflect.DeepEqual(p.Defaults.Quirks, defaults) {
+		t.Errorf("default quirks changed to %v", p.Defaults.Quirks)
+	}
+
+}
+
+func TestMerge(t *testing.T) {
+
+	yes, no, three := true, false, 3
+	pr := Profile{
+		Address: "10.47.1.5",
+		Retries: &three,
+		Quirks:  []string{"a", "b"},
+		Verify:  &yes,
+	}
+	pr.merge(&Profile{
+		Community: "c",
+		Quirks:    []string{"-a", "b", "c", "-d"},
+		Verify:    &no,
+	})
+
+	if pr.Address != "10.47.1.5" || pr.Community != "c" {
+		t.Errorf("address %q community %q", pr.Address, pr.Community)
+	}
+	if pr.Retries == nil || *pr.Retries != 3 {
+		t.Errorf("retries %v, want 3", pr.Retries)
+	}
+	if !reflect.DeepEqual(pr.Quirks, []string{"b", "c"}) {
+		t.Errorf("quirks %v, want [b c]", pr.Quirks)
+	}
+	if pr.Verify == nil || *pr.Verify {
+		t.Errorf("verify %v, want false", pr.Verify)
+	}
+
+	// a switch that says nothing of verify keeps the default
+	pr.merge(&Profile{})
+	if pr.Verify == nil || *pr.Verify {
+		t.Errorf("verify %v after an empty merge, want false", pr.Verify)
+	}
+
+}
+
+func TestResolveSecret(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("from-file\n")
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const env = "DETER_SNMP_TEST_SECRET"
+	os.Setenv(env, "from-env")
+	defer os.Unsetenv(env)
+
+	tests := []struct {
+		secret string
+		want   string
+		ok     bool
+	}{
+		{"inline", "inline", true},
+		{"", "", true},
+		{"env:" + env, "from-env", true},
+		{"file:" + f.Name(), "from-file", true},
+		{"env:DETER_SNMP_TEST_UNSET", "", false},
+		{"file:" + f.Name() + ".missing", "", false},
+	}
+	for _, x := range tests {
+		got, err := resolveSecret(x.secret)
+		if (err == nil) != x.ok {
+			t.Errorf("%q: error %v", x.secret, err)
+			continue
+		}
+		if got != x.want {
+			t.Errorf("%q: got %q, want %q", x.secret, got, x.want)
+		}
+	}
+
+}
+
+// Secrets of a profile are resolved when it is turned into options.
+func TestProfileSecrets(t *testing.T) {
+
+	const env = "DETER_SNMP_TEST_COMMUNITY"
+	os.Setenv(env, "s3cret")
+	defer os.Unsetenv(env)
+
+	p := loadProfiles(t, "defaults:\n  community: env:"+env+"\n")
+	_, _, opts, err := p.Resolve("leaf0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Community != "s3cret" {
+		t.Errorf("community %q, want s3cret", opts.Community)
+	}
+
+}
@@ -0,0 +1,123 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Port and Vlan Lists
+ * ====================================----------------------
+ *
+ * The code here expands the lists and ranges people write ports and vlans
+ * in. A list is comma separated and each item a single port or vid or a
+ * range of them,
+ *
+ *	1-24            bridge ports 1 to 24
+ *	1-8,17,33-40    bridge ports 1 to 8, 17 and 33 to 40
+ *	swp1-swp16      the interfaces named swp1 to swp16, swp1-16 for short
+ *	ifindex:1001-1004
+ *	100-199         vlans 100 to 199
+ *
+ * A range is two names that differ only in the number they end in. Port
+ * names are expanded without the switch, PortMap.ResolvePortList resolves
+ * them.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxRangeLen bounds the number of items a single range expands to, so a
+// slip of the finger does not make millions of ports.
+const MaxRangeLen = 4096
+
+// a range of names ending in numbers, swp1-swp16 or swp1-16
+var nameRange = regexp.MustCompile(`^(.*?)(\d+)-(.*?)(\d+)$`)
+
+// ParsePortList expands a list of ports into the names of the single ports
+// in it, in the order given and without duplicates. The names are in the
+// forms PortMap.Resolve accepts.
+func ParsePortList(list string) ([]string, error) {
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(list, ",") {
+		expanded, err := expandItem(item)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range expanded {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+
+}
+
+// ParseVids expands a list of vlans, 100-199,300 for example, into the vids
+// in it, in the order given and without duplicates. Every vid must be in the
+// 802.1Q range 1 to 4094.
+func ParseVids(list string) ([]int, error) {
+
+	names, err := ParsePortList(list)
+	if err != nil {
+		return nil, err
+	}
+	vids := make([]int, len(names))
+	for i, name := range names {
+		vid, err := strconv.Atoi(name)
+		if err != nil || vid < 1 || vid > 4094 {
+			return nil, fmt.Errorf("invalid vid %q", name)
+		}
+		vids[i] = vid
+	}
+	return vids, nil
+
+}
+
+// expandItem expands one item of a list, a name or a range of names.
+func expandItem(item string) ([]string, error) {
+
+	if item == "" {
+		return nil, fmt.Errorf("empty item in list")
+	}
+	m := nameRange.FindStringSubmatch(item)
+	if m == nil {
+		return []string{item}, nil
+	}
+	prefix, first, last := m[1], m[2], m[4]
+	if m[3] != "" && m[3] != prefix {
+		return nil, fmt.Errorf("invalid range %q, the ends differ in more "+
+			"than their number", item)
+	}
+
+	from, err := strconv.Atoi(first)
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %q: %v", item, err)
+	}
+	to, err := strconv.Atoi(last)
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %q: %v", item, err)
+	}
+	if to < from {
+		return nil, fmt.Errorf("invalid range %q, it runs backwards", item)
+	}
+	if to-from >= MaxRangeLen {
+		return nil, fmt.Errorf("range %q is longer than %d", item, MaxRangeLen)
+	}
+
+	// keep the width of zero padded numbers, Gi1/0/01-Gi1/0/12
+	format := "%s%d"
+	if len(first) > 1 && first[0] == '0' {
+		format = "%s%0" + strconv.Itoa(len(first)) + "d"
+	}
+	names := make([]string, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		names = append(names, fmt.Sprintf(format, prefix, n))
+	}
+	return names, nil
+
+}
@@ -0,0 +1,144 @@
+package snmp
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParsePortList(t *testing.T) {
+
+	tests := []struct {
+		list string
+		want []string // nil for an error
+	}{
+		{"12", []string{"12"}},
+		{"1-4", []string{"1", "2", "3", "4"}},
+		{"1-2,17,33-34", []string{"1", "2", "17", "33", "34"}},
+		{"swp1-swp3", []string{"swp1", "swp2", "swp3"}},
+		{"swp1-3", []string{"swp1", "swp2", "swp3"}},
+		{"swp9-11", []string{"swp9", "swp10", "swp11"}},
+		{"Gi1/0/1-Gi1/0/3", []string{"Gi1/0/1", "Gi1/0/2", "Gi1/0/3"}},
+		{"Gi1/0/08-Gi1/0/10", []string{"Gi1/0/08", "Gi1/0/09", "Gi1/0/10"}},
+		{"bridge:1-3", []string{"bridge:1", "bridge:2", "bridge:3"}},
+		{"ifindex:1001-1002", []string{"ifindex:1001", "ifindex:1002"}},
+
+		// the prefix holds a dash and digits of its own
+		{"ge-0/0/1-ge-0/0/4",
+			[]string{"ge-0/0/1", "ge-0/0/2", "ge-0/0/3", "ge-0/0/4"}},
+		{"ge-0/0/1-4",
+			[]string{"ge-0/0/1", "ge-0/0/2", "ge-0/0/3", "ge-0/0/4"}},
+		{"ge-0/0/1", []string{"ge-0/0/1"}},
+		{"uplink-a", []string{"uplink-a"}},
+
+		// duplicates are dropped, the first place counts
+		{"1-3,2,3-4", []string{"1", "2", "3", "4"}},
+		{"swp2,swp1-3", []string{"swp2", "swp1", "swp3"}},
+		{"5,5", []string{"5"}},
+
+		// one range a single port
+		{"7-7", []string{"7"}},
+
+		// mismatched prefixes
+		{"swp1-eth4", nil},
+		{"ge-0/0/1-ge-0/1/4", nil},
+		{"swp1-Swp4", nil},
+
+		// reversed ranges
+		{"4-1", nil},
+		{"swp16-swp1", nil},
+
+		// empty items
+		{"", nil},
+		{"1,,2", nil},
+		{"1,", nil},
+		{",1", nil},
+
+		// numbers out of range of an int
+		{"1-99999999999999999999", nil},
+	}
+	for _, x := range tests {
+		got, err := ParsePortList(x.list)
+		if x.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", x.list, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", x.list, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%q: got %v, want %v", x.list, got, x.want)
+		}
+	}
+
+}
+
+func TestParsePortListMaxRangeLen(t *testing.T) {
+
+	longest := "1-" + strconv.Itoa(MaxRangeLen)
+	got, err := ParsePortList(longest)
+	if err != nil {
+		t.Fatalf("%q: %v", longest, err)
+	}
+	if len(got) != MaxRangeLen {
+		t.Errorf("%q: got %d ports, want %d", longest, len(got), MaxRangeLen)
+	}
+
+	for _, list := range []string{
+		"1-" + strconv.Itoa(MaxRangeLen+1),
+		"swp0-swp" + strconv.Itoa(MaxRangeLen),
+		"1-2,1-" + strconv.Itoa(MaxRangeLen+1),
+	} {
+		_, err := ParsePortList(list)
+		if err == nil {
+			t.Errorf("%q: no error", list)
+		}
+	}
+
+}
+
+func TestParseVids(t *testing.T) {
+
+	tests := []struct {
+		list string
+		want []int // nil for an error
+	}{
+		{"1", []int{1}},
+		{"100-103", []int{100, 101, 102, 103}},
+		{"300,100-101,300", []int{300, 100, 101}},
+		{"4094", []int{4094}},
+		{"4090-4094", []int{4090, 4091, 4092, 4093, 4094}},
+
+		{"0", nil},
+		{"4095", nil},
+		{"4093-4095", nil},
+		{"-1", nil},
+		{"abc", nil},
+		{"vlan10", nil},
+		{"10-x", nil},
+		{"1.5", nil},
+		{"", nil},
+		{"10,,20", nil},
+		{"20-10", nil},
+	}
+	for _, x := range tests {
+		got, err := ParseVids(x.list)
+		if x.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", x.list, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", x.list, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%q: got %v, want %v", x.list, got, x.want)
+		}
+	}
+
+}
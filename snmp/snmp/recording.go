@@ -0,0 +1,115 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Recordings
+ * ====================================-------------
+ *
+ * The code here records the objects of a switch to a file, so the
+ * controller can be run and timed without the switch, see snmp-bench.
+ * Recordings use the numeric output format of snmpwalk, so
+ *
+ *	snmpwalk -v2c -c public -On switch .1.3.6.1.2.1.17 > switch.walk
+ *
+ * makes one as well. The agent that plays them back is in
+ * internal/snmptest.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/soniah/gosnmp"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// RecordedSubtrees are the subtrees Record saves by default, the ones the
+// controller reads.
+var RecordedSubtrees = []string{
+	".1.3.6.1.2.1.1",    // system
+	".1.3.6.1.2.1.2",    // interfaces
+	".1.3.6.1.2.1.17",   // bridge, q-bridge
+	".1.3.6.1.2.1.31",   // if-mib
+	".1.3.6.1.2.1.47",   // entity-mib
+	".1.0.8802.1.1.2.1", // lldp
+}
+
+// Record writes the objects in the provided subtrees of the switch to w in
+// snmpwalk -On format. With no subtrees RecordedSubtrees are written.
+func (c *SwitchControllerSnmp) Record(w io.Writer, subtrees ...string) error {
+
+	if len(subtrees) == 0 {
+		subtrees = RecordedSubtrees
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, tree := range subtrees {
+		err := bulkWalk(
+			c.client(),
+			[]string{"." + strings.TrimPrefix(tree, ".")},
+			c.maxRepetitions(),
+			c.maxOids(),
+			func(col string, v gosnmp.SnmpPDU) error {
+				line, ok := formatRecorded(v)
+				if ok {
+					_, err := fmt.Fprintln(bw, line)
+					return err
+				}
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+
+}
+
+// formatRecorded formats a pdu in snmpwalk -On format.
+func formatRecorded(v gosnmp.SnmpPDU) (string, bool) {
+
+	name := "." + strings.TrimPrefix(v.Name, ".")
+
+	var value string
+	switch v.Type {
+	case gosnmp.Integer:
+		value = fmt.Sprintf("INTEGER: %d", v.Value)
+	case gosnmp.Gauge32:
+		value = fmt.Sprintf("Gauge32: %d", v.Value)
+	case gosnmp.Counter32:
+		value = fmt.Sprintf("Counter32: %d", v.Value)
+	case gosnmp.Counter64:
+		value = fmt.Sprintf("Counter64: %d", v.Value)
+	case gosnmp.TimeTicks:
+		value = fmt.Sprintf("Timeticks: (%d)", v.Value)
+	case gosnmp.OctetString:
+		b := v.Value.([]byte)
+		if printable(b) {
+			value = fmt.Sprintf("STRING: %s", strconv.Quote(string(b)))
+		} else {
+			value = fmt.Sprintf("Hex-STRING: % X", b)
+		}
+	case gosnmp.ObjectIdentifier:
+		value = fmt.Sprintf("OID: %s", v.Value)
+	case gosnmp.IPAddress:
+		value = fmt.Sprintf("IpAddress: %s", v.Value)
+	default:
+		return "", false
+	}
+
+	return name + " = " + value, true
+
+}
+
+// printable returns whether b is printable ascii text.
+func printable(b []byte) bool {
+
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+
+}
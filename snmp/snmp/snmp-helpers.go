@@ -12,35 +12,62 @@ package snmp
 import (
 	"fmt"
 	"github.com/soniah/gosnmp"
-	"log"
-	"os"
-	"strconv"
-	"strings"
+	"time"
 )
 
 // NewGoSNMP creates a new SNMP Client. Target is the IP address, Community
-// the SNMP Community String and Version the SNMP version. Currently only v2c
-// is supported. Timeout parameter is measured in seconds.
+// the SNMP Community String and Version the SNMP version, which must be v2c.
+// SNMPv1 is not supported, see Options, and v3 needs the security parameters
+// NewGoSNMPOptions takes. Timeout parameter is measured in seconds. Each call
+// returns an independent session with its own connection, so clients for
+// different switches may be used concurrently. A single client must not be
+// shared between goroutines.
 func NewGoSNMP(
 	target, community string,
 	version gosnmp.SnmpVersion, timeout int64) (*gosnmp.GoSNMP, error) {
 
-	gosnmp.Default.Target = target
-	gosnmp.Default.Community = community
-	gosnmp.Default.Version = version
-	err := gosnmp.Default.Connect()
+	if version != gosnmp.Version2c {
+		return nil, fmt.Errorf("NewGoSNMP supports snmp v2c only, not v%v, "+
+			"use NewGoSNMPOptions for v3", version)
+	}
+	opts := &Options{
+		Version:   "2c",
+		Community: community,
+		Timeout:   time.Duration(timeout) * time.Second,
+	}
+
+	return NewGoSNMPOptions(target, opts)
+}
+
+// NewGoSNMPOptions creates a new SNMP Client for target as described by opts.
+// SNMPv3 sessions are supported through opts, see Options.
+func NewGoSNMPOptions(target string, opts *Options) (*gosnmp.GoSNMP, error) {
+
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	snmp, err := opts.session(target)
+	if err != nil {
+		return nil, err
+	}
+	err = snmp.Connect()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect: %v", err)
 	}
 
-	return gosnmp.Default, nil
+	return snmp, nil
 }
 
 // IsPortSet returns whether or not the port at index i is set within the
 // object ports which is an snmp style portlist data structure. For the
 // details of this structure see RFC 2674 in the Textual Conventions section.
+// Agents leave out the trailing zero octets of a portlist, so a port beyond
+// the end of ports is not set.
 func IsPortSet(i int, ports []byte) bool {
 
+	if i < 0 || i/8 >= len(ports) {
+		return false
+	}
 	bits := ports[i/8]
 	isSet := bits&(1<<uint(7-(i%8))) > 0
 	return isSet
@@ -69,18 +96,70 @@ func UnsetPort(i int, ports []byte) {
 
 }
 
+// portlistOr returns the union of the portlists a and b.
+func portlistOr(a, b []byte) []byte {
+
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	c := append([]byte(nil), a...)
+	for i := range b {
+		c[i] |= b[i]
+	}
+	return c
+
+}
+
+// portlistMissing returns whether any port set in a is not set in b.
+func portlistMissing(a, b []byte) bool {
+
+	for i := range a {
+		var x byte
+		if i < len(b) {
+			x = b[i]
+		}
+		if a[i]&^x != 0 {
+			return true
+		}
+	}
+	return false
+
+}
+
+// portlistPad returns ports extended with unset ports to size octets.
+func portlistPad(ports []byte, size int) []byte {
+
+	if len(ports) >= size {
+		return ports
+	}
+	padded := make([]byte, size)
+	copy(padded, ports)
+	return padded
+
+}
+
+// portlistEmpty returns whether no port is set in the portlist.
+func portlistEmpty(ports []byte) bool {
+
+	for _, b := range ports {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+
+}
+
 // getCounter retrieves a counter object from the device managed by the
 // provided snmp object at the provided oid.
-func getCounter(snmp *gosnmp.GoSNMP, oid string) (int, error) {
+func getCounter(snmp Agent, oid string) (int, error) {
 
 	resp, err := snmp.Get([]string{oid})
 	if err == nil {
 		for _, v := range resp.Variables {
 			switch v.Type {
-			case gosnmp.Integer:
-				return v.Value.(int), nil
-			case gosnmp.Gauge32:
-				return int(v.Value.(uint)), nil
+			case gosnmp.Integer, gosnmp.Gauge32, gosnmp.Counter32, gosnmp.TimeTicks:
+				return pduInt(v), nil
 			}
 		}
 	}
@@ -89,94 +168,90 @@ func getCounter(snmp *gosnmp.GoSNMP, oid string) (int, error) {
 
 }
 
-// walkf retrieves the entire subtree located at the provided oid and
-// processes each PDU encounted using the supplied function f
-func walkf(
-	snmp *gosnmp.GoSNMP,
-	oid string,
-	kind gosnmp.Asn1BER,
-	f func(int, gosnmp.SnmpPDU) error) error {
+// set performs an snmp SET of the provided pdus as a single request. A
+// transport failure is returned as is, a non zero error-status in the
+// response is returned as a *SetError.
+func set(snmp Agent, pdus []gosnmp.SnmpPDU) error {
 
-	resp, err := snmp.BulkWalkAll(oid)
+	pkt, err := snmp.Set(pdus)
 	if err != nil {
-		return err
+		return fmt.Errorf("snmp set %s failed: %v", pdus[0].Name, err)
 	}
-	if err == nil {
-		for i, v := range resp {
-			switch v.Type {
-			case kind:
-				err = f(i, v)
-				if err != nil {
-					return err
-				}
-			}
+	if pkt.Error != gosnmp.NoError {
+		e := &SetError{
+			Oid:    pdus[0].Name,
+			Status: pkt.Error,
+			Index:  int(pkt.ErrorIndex),
+		}
+		if e.Index > 0 && e.Index <= len(pdus) {
+			e.Oid = pdus[e.Index-1].Name
 		}
+		return e
 	}
 
 	return nil
-}
 
-// destroyRow marks an snmp table row located at the specified oid
-// for destruction.
-func destroyRow(snmp *gosnmp.GoSNMP, oid string) error {
-
-	pdu := gosnmp.SnmpPDU{
-		Name:   oid,
-		Type:   gosnmp.Integer,
-		Value:  6,
-		Logger: log.New(os.Stdout, "", 0)}
+}
 
-	pkt, err := snmp.Set([]gosnmp.SnmpPDU{pdu})
-	if err != nil {
-		log.Printf("%#v", pkt)
-		return err
+// setSequence performs an snmp SET of each of the provided pdus in turn,
+// stopping at the first failure which is returned as a *StepError.
+func setSequence(snmp Agent, pdus []gosnmp.SnmpPDU) error {
+
+	for i, pdu := range pdus {
+		err := set(snmp, []gosnmp.SnmpPDU{pdu})
+		if err != nil {
+			return &StepError{
+				Step:  i + 1,
+				Steps: len(pdus),
+				Oid:   pdu.Name,
+				Err:   err,
+			}
+		}
 	}
 
 	return nil
 
 }
 
-// destroyRow creates a new snmp table row at the provided oid.
-func createRow(snmp *gosnmp.GoSNMP, oid string) error {
+// destroyRow marks an snmp table row located at the specified oid
+// for destruction.
+func destroyRow(snmp Agent, oid string) error {
 
-	pdu := gosnmp.SnmpPDU{
-		Name:   oid,
-		Type:   gosnmp.Integer,
-		Value:  1,
-		Logger: log.New(os.Stdout, "", 0)}
+	return set(snmp, []gosnmp.SnmpPDU{{
+		Name:  oid,
+		Type:  gosnmp.Integer,
+		Value: 6,
+	}})
 
-	pkt, err := snmp.Set([]gosnmp.SnmpPDU{pdu})
+}
 
-	if err != nil {
-		log.Printf("%#v", pkt)
-		return err
-	}
-	if pkt.Error != 0 {
-		log.Printf("object creation failed (%d)", pkt.Error)
-	}
+// createRow creates a new snmp table row at the provided oid.
+func createRow(snmp Agent, oid string) error {
 
-	return nil
+	return set(snmp, []gosnmp.SnmpPDU{{
+		Name:  oid,
+		Type:  gosnmp.Integer,
+		Value: 1,
+	}})
 
 }
 
 // setOctetString sets the value of the octet string located at the
 // specified oid
-func setOctetString(snmp *gosnmp.GoSNMP, oid string, value []byte) error {
+func setOctetString(snmp Agent, oid string, value []byte) error {
 
-	pdu := gosnmp.SnmpPDU{
-		Name:   oid,
-		Type:   gosnmp.OctetString,
-		Value:  value,
-		Logger: log.New(os.Stdout, "", 0)}
+	return set(snmp, []gosnmp.SnmpPDU{octetStringPdu(oid, value)})
 
-	pkt, err := snmp.Set([]gosnmp.SnmpPDU{pdu})
+}
 
-	if err != nil {
-		log.Printf("%#v", pkt)
-		return err
-	}
+// octetStringPdu creates a pdu that sets the octet string at oid to value.
+func octetStringPdu(oid string, value []byte) gosnmp.SnmpPDU {
 
-	return nil
+	return gosnmp.SnmpPDU{
+		Name:  oid,
+		Type:  gosnmp.OctetString,
+		Value: value,
+	}
 
 }
 
@@ -185,6 +260,10 @@ func setOctetString(snmp *gosnmp.GoSNMP, oid string, value []byte) error {
 
 const (
 	interfaceBridgeIndexOid = ".1.3.6.1.2.1.17.1.4.1.2"
+	interfaceNameOid        = ".1.3.6.1.2.1.31.1.1.1.1"
+	interfaceAliasOid       = ".1.3.6.1.2.1.31.1.1.1.18"
+	sysUpTimeOid            = ".1.3.6.1.2.1.1.3.0"
+	entAliasMappingOid      = ".1.3.6.1.2.1.47.1.3.2.1.2"
 )
 
 func interfacePropertyOid(x int) string {
@@ -205,6 +284,54 @@ func staticVlanPropertyOid(x int) string {
 
 }
 
+func portVlanPropertyOid(x int) string {
+
+	return fmt.Sprintf(".1.3.6.1.2.1.17.7.1.4.5.1.%d", x)
+
+}
+
+func pvidOid(port int) string {
+
+	return fmt.Sprintf("%s.%d", portVlanPropertyOid(1), port)
+
+}
+
+func fdbPropertyOid(x int) string {
+
+	return fmt.Sprintf(".1.3.6.1.2.1.17.4.3.1.%d", x)
+
+}
+
+func qFdbPropertyOid(x int) string {
+
+	return fmt.Sprintf(".1.3.6.1.2.1.17.7.1.2.2.1.%d", x)
+
+}
+
+func entPhysicalPropertyOid(x int) string {
+
+	return fmt.Sprintf(".1.3.6.1.2.1.47.1.1.1.1.%d", x)
+
+}
+
+func lldpLocPortPropertyOid(x int) string {
+
+	return fmt.Sprintf(".1.0.8802.1.1.2.1.3.7.1.%d", x)
+
+}
+
+func lldpRemPropertyOid(x int) string {
+
+	return fmt.Sprintf(".1.0.8802.1.1.2.1.4.1.1.%d", x)
+
+}
+
+func lldpRemManAddrPropertyOid(x int) string {
+
+	return fmt.Sprintf(".1.0.8802.1.1.2.1.4.2.1.%d", x)
+
+}
+
 func vlanEgressOid(x int) string {
 
 	return fmt.Sprintf("%s.%d", staticVlanPropertyOid(2), x)
@@ -216,10 +343,3 @@ func vlanAccessOid(x int) string {
 	return fmt.Sprintf("%s.%d", staticVlanPropertyOid(4), x)
 
 }
-
-func extractLLDPIndex(oid string) (int, error) {
-	b := strings.LastIndex(oid, ".")
-	a := strings.LastIndex(oid[:b], ".")
-	i, err := strconv.ParseInt(oid[a+1:b], 10, 0)
-	return int(i), err
-}
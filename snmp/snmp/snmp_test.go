@@ -0,0 +1,216 @@
+package snmp
+
+import (
+	"github.com/deter-project/switch-drivers/snmp/internal/snmptest"
+	"github.com/soniah/gosnmp"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// leaf0.walk is an 8 port switch. Ports 1-4 are access ports of vlan 1,
+// 5 and 6 of vlan 10 and 7 of vlan 20. Port 8 is a trunk of vlans 10, 20
+// and 30 with pvid 10. Vlan 20 has no name and vlan 30 no untagged list,
+// as agents leave out empty cells. LLDP sees node2 on port 2 and sw1,
+// which has no mac address chassis id, on port 8. Both forwarding tables
+// hold the six addresses learned, dot1qTpFdbTable in filtering databases
+// numbered as the vlans, and dot1dTpFdbTable also the address of the
+// switch.
+const fixture = "testdata/leaf0.walk"
+
+// benchLatency is the round trip to the recording in benchmarks, so the
+// number of requests shows in the time as it does with a switch.
+const benchLatency = time.Millisecond
+
+// loadFixture returns a controller for a fresh copy of the fixture and the
+// recording it talks to.
+func loadFixture(tb testing.TB, opts *Options) (*SwitchControllerSnmp, *snmptest.Recording) {
+
+	rec, err := snmptest.LoadRecording(fixture)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	return NewSwitchControllerAgent(rec, opts), rec
+
+}
+
+// editFixture returns a controller for the fixture without the objects
+// under the prefixes in drop and with the objects in add, which are in
+// snmpwalk -On format.
+func editFixture(tb testing.TB, drop []string, add string) *SwitchControllerSnmp {
+
+	walk, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(walk), "\n") {
+		keep := true
+		for _, prefix := range drop {
+			if strings.HasPrefix(line, prefix+".") {
+				keep = false
+			}
+		}
+		if keep {
+			lines = append(lines, line)
+		}
+	}
+	lines = append(lines, add)
+
+	rec, err := snmptest.ReadRecording(
+		strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return NewSwitchControllerAgent(rec, &Options{})
+
+}
+
+func TestFixtureReads(t *testing.T) {
+
+	c, _ := loadFixture(t, nil)
+
+	ifxs, err := c.GetInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ifxs) != 8 {
+		t.Errorf("got %d interfaces, want 8", len(ifxs))
+	}
+
+	vlans, err := c.GetVlans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vlans) != 4 {
+		t.Errorf("got %d vlans, want 4", len(vlans))
+	}
+
+	nbrs, err := c.GetNeighbors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nbrs) != 2 {
+		t.Errorf("got %d neighbors, want 2", len(nbrs))
+	}
+
+	fdb, err := c.GetFdb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fdb) != 6 {
+		t.Errorf("got %d fdb entries, want 6", len(fdb))
+	}
+
+}
+
+// A controller made as a literal, without options or a way to open more
+// sessions, reads and writes with the defaults.
+func TestControllerLiteral(t *testing.T) {
+
+	rec, err := snmptest.LoadRecording(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &SwitchControllerSnmp{agent: rec}
+
+	ifxs, err := c.GetInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ifxs) != 8 {
+		t.Errorf("got %d interfaces, want 8", len(ifxs))
+	}
+	err = c.SetPortAccess([]int{1}, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &gosnmp.GoSNMP{}
+	c = &SwitchControllerSnmp{Snmp: s}
+	if c.client() != s {
+		t.Error("a controller made around a session does not send through it")
+	}
+
+}
+
+// A controller made without options uses the defaults and does not read the
+// profiles file.
+func TestNewSwitchControllerDefaults(t *testing.T) {
+
+	old, set := os.LookupEnv(ProfilesEnv)
+	os.Setenv(ProfilesEnv, "testdata/no-such-profiles.yml")
+	defer func() {
+		if set {
+			os.Setenv(ProfilesEnv, old)
+		} else {
+			os.Unsetenv(ProfilesEnv)
+		}
+	}()
+
+	c, err := NewSwitchControllerSnmp("127.0.0.1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Snmp.Community != DefaultOptions().Community {
+		t.Errorf("community %q, want the default", c.Snmp.Community)
+	}
+
+}
+
+// benchmark runs op against the fixture with benchLatency per request.
+func benchmark(b *testing.B, op func(c *SwitchControllerSnmp) error) {
+
+	c, rec := loadFixture(b, nil)
+	rec.Latency = benchLatency
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := op(c)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+}
+
+func BenchmarkGetInterfaces(b *testing.B) {
+	benchmark(b, func(c *SwitchControllerSnmp) error {
+		_, err := c.GetInterfaces()
+		return err
+	})
+}
+
+func BenchmarkGetVlans(b *testing.B) {
+	benchmark(b, func(c *SwitchControllerSnmp) error {
+		_, err := c.GetVlans()
+		return err
+	})
+}
+
+func BenchmarkGetNeighbors(b *testing.B) {
+	benchmark(b, func(c *SwitchControllerSnmp) error {
+		_, err := c.GetNeighbors()
+		return err
+	})
+}
+
+func BenchmarkGetPortMap(b *testing.B) {
+	benchmark(b, func(c *SwitchControllerSnmp) error {
+		_, err := c.GetPortMap()
+		return err
+	})
+}
+
+// the read every Set method does before it writes
+func BenchmarkVlanTable(b *testing.B) {
+	benchmark(b, func(c *SwitchControllerSnmp) error {
+		_, err := c.Begin().Commit()
+		return err
+	})
+}
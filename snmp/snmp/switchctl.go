@@ -13,8 +13,6 @@ package snmp
 import (
 	"fmt"
 	"github.com/soniah/gosnmp"
-	"math"
-	"strconv"
 )
 
 ///            ----------------------------------------------------------------
@@ -22,310 +20,284 @@ import (
 ///  --------------------------------------------------------------------------
 type SwitchControllerSnmp struct {
 	Snmp *gosnmp.GoSNMP
+	opts *Options
+
+	// agent is what requests are sent through, Snmp or a recording. dial
+	// opens the extra sessions used for concurrent table walks, which are
+	// kept in pool.
+	agent Agent
+	dial  func() (Agent, error)
+	pool  []Agent
+}
+
+// An Agent sends snmp requests to a switch. *gosnmp.GoSNMP is the Agent used
+// for real switches, a snmptest.Recording stands in for one in tests.
+type Agent interface {
+	Get(oids []string) (*gosnmp.SnmpPacket, error)
+	GetBulk(oids []string, nonRepeaters uint8, maxRepetitions uint8) (*gosnmp.SnmpPacket, error)
+	Set(pdus []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error)
+}
+
+// SwitchControllerSnmp is the Q-BRIDGE implementation of SwitchController.
+var _ SwitchController = (*SwitchControllerSnmp)(nil)
+
+func init() {
+	RegisterDriver(DefaultDriver,
+		func(address string, opts *Options) (SwitchController, error) {
+			return NewSwitchControllerSnmp(address, opts)
+		})
 }
 
 // NewSwitchControllerSNMP creates a new switch controller that controls a
-// switch located at the specified address.
-func NewSwitchControllerSnmp(address string) (*SwitchControllerSnmp, error) {
+// switch located at the specified address. Every controller owns its own snmp
+// session, so controllers for different switches may be driven from
+// different goroutines. When opts is nil DefaultOptions are used, profiles
+// are only consulted by callers that resolve the address through them, see
+// ResolveHost.
+func NewSwitchControllerSnmp(
+	address string, opts *Options) (*SwitchControllerSnmp, error) {
+
+	if opts == nil {
+		opts = DefaultOptions()
+	}
 
-	s := new(SwitchControllerSnmp)
-	snmp, err := NewGoSNMP(address, "public", gosnmp.Version2c, 5)
+	snmp, err := NewGoSNMPOptions(address, opts)
 	if err != nil {
 		return nil, err
 	}
+	s := NewSwitchControllerAgent(snmp, opts)
 	s.Snmp = snmp
+	s.dial = func() (Agent, error) {
+		a, err := NewGoSNMPOptions(address, opts)
+		if err != nil {
+			return nil, err
+		}
+		return traced(a, opts), nil
+	}
 	return s, nil
 
 }
 
-// GetInterfaces fetches the interface infrormation from the switch organized
-// as a list of Interface objects.
-func (c *SwitchControllerSnmp) GetInterfaces() ([]Interface, error) {
+// NewSwitchControllerAgent creates a switch controller that sends its
+// requests through agent, for example a snmptest.Recording. Concurrent table walks
+// share the agent, which must then be safe for concurrent use.
+func NewSwitchControllerAgent(agent Agent, opts *Options) *SwitchControllerSnmp {
 
-	/*
-		TODO This fails with snmpd. Have brought this up with the mailing list.
-				 Until this gets resolved on that end, using walk instead as per below
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	agent = traced(agent, opts)
+	return &SwitchControllerSnmp{
+		opts:  opts,
+		agent: agent,
+		dial: func() (Agent, error) {
+			return agent, nil
+		},
+	}
 
-		numIfx, err := getCounter(c.Snmp, "1.3.6.1.2.1.2.1.0")
-		if err != nil {
-			return nil, err
-		}
-	*/
-
-	//bridge_ifx_map := make(map[int]*Interface)
-	//device_ifx_map := make(map[int]*
-
-	numIfx := 0
-	err := walkf(
-		c.Snmp,
-		"1.3.6.1.2.1.2.1.0",
-		gosnmp.Integer,
-		func(i int, v gosnmp.SnmpPDU) error {
-			numIfx = v.Value.(int)
-			return nil
-		})
+}
 
-	result := make([]Interface, numIfx)
+// Close closes the snmp connections to the switch under control.
+func (c *SwitchControllerSnmp) Close() error {
 
-	if err != nil || numIfx == 0 {
-		return result, nil
+	var result error
+	for _, a := range append([]Agent{c.client()}, c.pool...) {
+		s, ok := untraced(a).(*gosnmp.GoSNMP)
+		if !ok || s.Conn == nil {
+			continue
+		}
+		err := s.Conn.Close()
+		if err != nil && result == nil {
+			result = err
+		}
 	}
+	c.pool = nil
+	return result
 
-	devidx := make(map[int]int)
+}
 
-	//indices
-	err = walkf(
-		c.Snmp,
-		interfacePropertyOid(1),
-		gosnmp.Integer,
-		func(i int, v gosnmp.SnmpPDU) error {
-			idx := v.Value.(int)
-			if i >= len(result) {
-				fmt.Printf(
-					"warning: the switch told us it has %d interfaces "+
-						"but this is interface # %d\n",
-					len(result), i,
-				)
-				return nil
-			}
-			result[i].Index = idx
-			devidx[idx] = i
-			return nil
-		})
+// client returns the agent requests are sent through. A controller made as
+// a SwitchControllerSnmp literal around a session sends them through Snmp.
+func (c *SwitchControllerSnmp) client() Agent {
 
-	//bridge indices
-	err = walkf(
-		c.Snmp,
-		interfaceBridgeIndexOid,
-		gosnmp.Integer,
-		func(i int, v gosnmp.SnmpPDU) error {
-			//extract the index from the oid
-			idx := v.Value.(int)
-			d_idx, ok := devidx[idx]
-			if ok {
-				suffix := v.Name[len(interfaceBridgeIndexOid)+1:]
-				//fmt.Printf("%s - %s\n", v.Name, suffix)
-				index, err := strconv.Atoi(suffix)
-				if err != nil {
-					return err
-				}
-				result[d_idx].BridgeIndex = index
-			}
-			return nil
-		})
+	if c.agent == nil && c.Snmp != nil {
+		c.agent = c.Snmp
+	}
+	return c.agent
 
-	//labels
-	err = walkf(
-		c.Snmp,
-		interfacePropertyOid(2),
-		gosnmp.OctetString,
-		func(i int, v gosnmp.SnmpPDU) error {
-			if i >= len(result) {
-				return nil
-			}
-			result[i].Label = string(v.Value.([]byte))
-			return nil
-		})
+}
 
-	err = walkf(
-		c.Snmp,
-		".1.3.6.1.2.1.31.1.1.1.18",
-		gosnmp.OctetString,
-		func(i int, v gosnmp.SnmpPDU) error {
-			if i >= len(result) {
-				return nil
-			}
-			result[i].Label += " " + string(v.Value.([]byte))
-			return nil
-		})
+// options returns the options of the controller, the defaults for one made
+// without them.
+func (c *SwitchControllerSnmp) options() *Options {
 
-	//physical layer types
-	err = walkf(
-		c.Snmp,
-		interfacePropertyOid(3),
-		gosnmp.Integer,
-		func(i int, v gosnmp.SnmpPDU) error {
-			if i >= len(result) {
-				return nil
-			}
-			result[i].Kind = v.Value.(int)
-			return nil
-		})
+	if c.opts == nil {
+		return DefaultOptions()
+	}
+	return c.opts
 
-	//admin status
-	err = walkf(
-		c.Snmp,
-		interfacePropertyOid(7),
-		gosnmp.Integer,
-		func(i int, v gosnmp.SnmpPDU) error {
-			if i >= len(result) {
-				return nil
-			}
-			result[i].AdminStatus = v.Value.(int)
-			return nil
-		})
+}
 
-	//operational status
-	err = walkf(
-		c.Snmp,
-		interfacePropertyOid(8),
-		gosnmp.Integer,
-		func(i int, v gosnmp.SnmpPDU) error {
-			if i >= len(result) {
-				return nil
-			}
-			result[i].OpStatus = v.Value.(int)
-			return nil
-		})
+// sessions returns up to n agents to walk tables with concurrently, the
+// first being the agent of the controller. Extra sessions are opened on
+// first use and kept until Close. A controller that cannot open sessions of
+// its own gets just the one.
+func (c *SwitchControllerSnmp) sessions(n int) ([]Agent, error) {
 
-	return result, nil
+	if c.dial == nil {
+		return []Agent{c.client()}, nil
+	}
+	for len(c.pool) < n-1 {
+		a, err := c.dial()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open session: %v", err)
+		}
+		c.pool = append(c.pool, a)
+	}
+	return append([]Agent{c.client()}, c.pool[:n-1]...), nil
 
 }
 
-type Neighbor struct {
-	LocalIfIndex      int
-	BridgeIfIndex     int
-	RemoteMac         []byte
-	RemoteName        string
-	RemotePortName    string
-	RemoteDescription string
+// maxOids returns the largest number of varbinds to put in one request.
+func (c *SwitchControllerSnmp) maxOids() int {
+
+	if c.Snmp != nil && c.Snmp.MaxOids > 0 {
+		return c.Snmp.MaxOids
+	}
+	return gosnmp.MaxOids
+
 }
 
-// GetNeighbors fetches the hosts that are directly plugged into the switch.
-// At this time we gather this information by reading the LLDP tables.
-func (c *SwitchControllerSnmp) GetNeighbors() (map[int]*Neighbor, error) {
+// GetInterfaces fetches the interface infrormation from the switch organized
+// as a list of Interface objects. The ifTable and ifXTable columns are joined
+// by ifIndex, so interfaces that lack a cell, an ifAlias for example, still
+// get the rest of their properties.
+func (c *SwitchControllerSnmp) GetInterfaces() ([]Interface, error) {
 
-	ifxs, err := c.GetInterfaces()
+	rows, err := c.walkTable(
+		interfacePropertyOid(2),
+		interfacePropertyOid(3),
+		interfacePropertyOid(7),
+		interfacePropertyOid(8),
+		interfaceAliasOid,
+	)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error reading interfaces: %v", err)
 	}
-	nbrs := make(map[int]*Neighbor)
 
-	bridgeIf := func(index int) int {
-		for _, x := range ifxs {
-			if x.Index == index {
-				return x.BridgeIndex
-			}
+	result := make([]Interface, 0, len(rows))
+	devidx := make(map[int]int)
+	for _, r := range rows {
+		x := Interface{
+			Index:       r.last(),
+			Label:       r.str(interfacePropertyOid(2)),
+			Kind:        r.integer(interfacePropertyOid(3)),
+			AdminStatus: r.integer(interfacePropertyOid(7)),
+			OpStatus:    r.integer(interfacePropertyOid(8)),
 		}
-		return 0
+		if r.has(interfaceAliasOid) {
+			x.Label += " " + r.str(interfaceAliasOid)
+		}
+		devidx[x.Index] = len(result)
+		result = append(result, x)
 	}
 
-	//get the macs
-	err = walkf(
-		c.Snmp,
-		".1.0.8802.1.1.2.1.4.1.1.7",
-		gosnmp.OctetString,
-		func(i int, v gosnmp.SnmpPDU) error {
-			mac := v.Value.([]byte)
-			i, err := extractLLDPIndex(v.Name)
-			if err == nil {
-				nbrs[i] = &Neighbor{
-					LocalIfIndex:  i,
-					BridgeIfIndex: bridgeIf(i),
-					RemoteMac:     mac,
-				}
-				return nil
-			} else {
-				return err
-			}
-		},
-	)
+	//bridge indices, dot1dBasePortIfIndex is indexed by bridge port
+	rows, err = c.walkTable(interfaceBridgeIndexOid)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bridge ports: %v", err)
+	}
+	for _, r := range rows {
+		d_idx, ok := devidx[r.integer(interfaceBridgeIndexOid)]
+		if ok {
+			result[d_idx].BridgeIndex = r.last()
+		}
+	}
+
+	err = c.getPortVlans(result)
 	if err != nil {
-		return nbrs, fmt.Errorf("error reading neighbor macs %v", err)
+		return nil, err
 	}
 
-	walkFor := func(oid string, what func(n *Neighbor) *string) {
-		walkf(
-			c.Snmp,
-			oid,
-			gosnmp.OctetString,
-			func(i int, v gosnmp.SnmpPDU) error {
-				value_ := string(v.Value.([]byte))
-				i, err := extractLLDPIndex(v.Name)
-				if err == nil {
-					*(what(nbrs[i])) = value_
-					return nil
-				} else {
-					return err
-				}
-			},
-		)
+	return result, nil
+
+}
 
+// getPortVlans fills in the port vlan settings of the bridge ports in ifxs.
+func (c *SwitchControllerSnmp) getPortVlans(ifxs []Interface) error {
+
+	bridge := make(map[int]*Interface)
+	for i := range ifxs {
+		if ifxs[i].BridgeIndex != 0 {
+			bridge[ifxs[i].BridgeIndex] = &ifxs[i]
+		}
 	}
 
-	//get the hostnames
-	walkFor(".1.0.8802.1.1.2.1.4.1.1.9",
-		func(n *Neighbor) *string { return &n.RemoteName })
+	rows, err := c.walkTable(
+		portVlanPropertyOid(1),
+		portVlanPropertyOid(2),
+		portVlanPropertyOid(3),
+	)
+	if err != nil {
+		return fmt.Errorf("error reading port vlan settings: %v", err)
+	}
 
-	//get the port names
-	walkFor(".1.0.8802.1.1.2.1.4.1.1.8",
-		func(n *Neighbor) *string { return &n.RemotePortName })
+	for _, r := range rows {
+		x, ok := bridge[r.last()]
+		if !ok {
+			continue
+		}
+		x.Pvid = r.integer(portVlanPropertyOid(1))
+		x.AcceptableFrameTypes = r.integer(portVlanPropertyOid(2))
+		x.IngressFiltering = r.integer(portVlanPropertyOid(3)) == 1
+	}
 
-	//get system description
-	walkFor(".1.0.8802.1.1.2.1.4.1.1.10",
-		func(n *Neighbor) *string { return &n.RemoteDescription })
+	return nil
 
-	return nbrs, nil
 }
 
-// GetVlans fetches the vlan information from the switch organized as a list
-// of Vlan objects.
-func (c *SwitchControllerSnmp) GetVlans() ([]Vlan, error) {
+// getPvids fetches the port vlan id of every bridge port, keyed by bridge
+// port.
+func (c *SwitchControllerSnmp) getPvids() (map[int]int, error) {
 
-	numVlan, err := getCounter(c.Snmp, ".1.3.6.1.2.1.17.7.1.1.4.0")
+	rows, err := c.walkTable(portVlanPropertyOid(1))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error reading port vlan ids: %v", err)
 	}
 
-	result := make([]Vlan, numVlan)
+	pvids := make(map[int]int)
+	for _, r := range rows {
+		pvids[r.last()] = r.integer(portVlanPropertyOid(1))
+	}
 
-	//indices
-	err = walkf(
-		c.Snmp,
-		currentVlanPropertyOid(3),
-		gosnmp.Gauge32,
-		func(i int, v gosnmp.SnmpPDU) error {
-			result[i].Index = int(v.Value.(uint))
-			return nil
-		})
+	return pvids, nil
 
-	//egress ports
-	err = walkf(
-		c.Snmp,
-		staticVlanPropertyOid(2),
-		gosnmp.OctetString,
-		func(i int, v gosnmp.SnmpPDU) error {
-			result[i].EgressPorts = v.Value.([]byte)
-			return nil
-		})
+}
 
-	//access ports
-	err = walkf(
-		c.Snmp,
-		staticVlanPropertyOid(4),
-		gosnmp.OctetString,
-		func(i int, v gosnmp.SnmpPDU) error {
-			result[i].AccessPorts = v.Value.([]byte)
-			return nil
-		})
+// GetVlans fetches the vlan information from the switch organized as a list
+// of Vlan objects, one for every row of dot1qVlanStaticTable in vid order.
+// The static table is the one the Set methods write, dot1qVlanCurrentTable is
+// indexed by a TimeMark as well as the vid and is not consulted.
+func (c *SwitchControllerSnmp) GetVlans() ([]Vlan, error) {
 
-	//names
-	err = walkf(
-		c.Snmp,
+	rows, err := c.walkTable(
 		staticVlanPropertyOid(1),
-		gosnmp.OctetString,
-		func(i int, v gosnmp.SnmpPDU) error {
-			result[i].Name = string(v.Value.([]byte))
-
-			//extract the index from the oid
-			suffix := v.Name[len(staticVlanPropertyOid(1))+1:]
-			index, err := strconv.Atoi(suffix)
-			if err != nil {
-				return err
-			}
-			result[i].Index = index
-			return nil
+		staticVlanPropertyOid(2),
+		staticVlanPropertyOid(4),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error reading vlans: %v", err)
+	}
+
+	result := make([]Vlan, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, Vlan{
+			Index:       r.last(),
+			Name:        r.str(staticVlanPropertyOid(1)),
+			EgressPorts: r.octets(staticVlanPropertyOid(2)),
+			AccessPorts: r.octets(staticVlanPropertyOid(4)),
 		})
+	}
 
 	return result, nil
 
@@ -334,7 +306,7 @@ func (c *SwitchControllerSnmp) GetVlans() ([]Vlan, error) {
 // DeleteVlan removes the specified vlan from the switch under control
 func (c *SwitchControllerSnmp) DeleteVlan(number int) error {
 
-	return destroyRow(c.Snmp,
+	return destroyRow(c.client(),
 		fmt.Sprintf(".1.3.6.1.2.1.17.7.1.4.3.1.5.%d", number))
 
 }
@@ -342,205 +314,130 @@ func (c *SwitchControllerSnmp) DeleteVlan(number int) error {
 // CreateVlan creates the specified vlan on the switch under control.
 func (c *SwitchControllerSnmp) CreateVlan(number int) error {
 
-	return createRow(c.Snmp,
+	return createRow(c.client(),
 		fmt.Sprintf(".1.3.6.1.2.1.17.7.1.4.3.1.5.%d", number))
 
 }
 
 // SetPortAccess sets vlan access for the provided vlan number on the
-// specified ports.
+// specified ports. The egress and untagged portlists are written in a single
+// request, see apply.
 func (c *SwitchControllerSnmp) SetPortAccess(ports []int, number int) error {
 
-	vlans, err := c.GetVlans()
-	if err != nil {
-		return fmt.Errorf("SetPortAccess: GetVlans failed: %v", err)
-	}
+	return c.stage("SetPortAccess", func(t *vlanTable) error {
+		return t.setPortAccess(ports, number)
+	})
 
-	for _, v := range vlans {
-		if v.Index == number {
-			for _, p := range ports {
-				SetPort(p-1, v.EgressPorts)
-				SetPort(p-1, v.AccessPorts)
-			}
-			setOctetString(c.Snmp, vlanEgressOid(number), v.EgressPorts)
-			setOctetString(c.Snmp, vlanAccessOid(number), v.AccessPorts)
-			return nil
-		}
-	}
+}
 
-	//an existing vlan as not found, so let's make one
-	/*
-		bridge_size, err := getCounter(c.Snmp, ".1.3.6.1.2.1.17.1.2.0")
-		if err != nil {
-			return err
-		}
-		portmap_size := int(math.Ceil(float64(bridge_size) / 8.0))
-		portmap := make([]byte, portmap_size)
-		for _, p := range ports {
-			SetPort(p-1, portmap)
-			SetPort(p-1, portmap)
-		}
-		setOctetString(c.Snmp, vlanEgressOid(number), portmap)
-		setOctetString(c.Snmp, vlanAccessOid(number), portmap)
-		return nil
-	*/
-	return c.newVlan(ports, number, true)
+// MovePortAccess makes the specified ports access ports of the provided vlan
+// and of no other, "this port is now in vlan X". The ports are removed from
+// the egress and untagged lists of every other vlan, added to both lists of
+// the vlan and get it as their pvid, all in a single request, see apply.
+func (c *SwitchControllerSnmp) MovePortAccess(ports []int, number int) error {
 
-	//return fmt.Errorf("vlan %d does not exist", number)
+	return c.stage("MovePortAccess", func(t *vlanTable) error {
+		return t.movePortAccess(ports, number)
+	})
 
 }
 
-func (c *SwitchControllerSnmp) newVlan(ports []int, number int, access bool) error {
-	bridge_size, err := getCounter(c.Snmp, ".1.3.6.1.2.1.17.1.2.0")
-	if err != nil {
-		return err
-	}
-	portmap_size := int(math.Ceil(float64(bridge_size) / 8.0))
-	portmap := make([]byte, portmap_size)
-	for _, p := range ports {
-		SetPort(p-1, portmap)
-	}
-	setOctetString(c.Snmp, vlanEgressOid(number), portmap)
-	if access {
-		setOctetString(c.Snmp, vlanAccessOid(number), portmap)
-	}
-	return nil
-}
+// A TrunkMode says how the vlans given to SetPortTrunk relate to the vlans a
+// port already carries.
+type TrunkMode int
 
-// SetPortTrunk sets a vlan trunk for the provided vlan numbers on the
-// specified ports on the switch under control.
-func (c *SwitchControllerSnmp) SetPortTrunk(ports []int, numbers []int) error {
+const (
+	// TrunkAdd adds the vlans to the ones the port already carries.
+	TrunkAdd TrunkMode = iota
 
-	vlans, err := c.GetVlans()
-	if err != nil {
-		return fmt.Errorf("SetPortTrunk: GetVlans failed: %v", err)
-	}
+	// TrunkReplace makes the vlans, and the native vlan, exactly the ones the
+	// port carries.
+	TrunkReplace
+)
 
-	for _, number := range numbers {
-		found := false
-		for _, v := range vlans {
-			if v.Index == number {
-				found = true
-				for _, p := range ports {
-					SetPort(p-1, v.EgressPorts)
-				}
-				setOctetString(c.Snmp, vlanEgressOid(number), v.EgressPorts)
-				//setOctetString(c.Snmp, vlanAccessOid(number), v.AccessPorts)
-				break
-			}
-		}
-		//if vlan does not exist create a new one
-		if !found {
-			err = c.newVlan(ports, number, false)
-			if err != nil {
-				return err
-			}
-		}
-	}
+// TrunkOptions are the settings of a trunk beyond its vlans. The zero value
+// adds the vlans to the trunk and leaves its native vlan as is.
+type TrunkOptions struct {
+	// Native, if not 0, is the native vlan of the ports. They are untagged
+	// in it and get it as their pvid, any other vlan they were untagged in is
+	// removed from them.
+	Native int
 
-	return nil
+	// Mode says how the vlans relate to the ones the ports carry.
+	Mode TrunkMode
+}
+
+// SetPortTrunk configures the specified ports as trunks of the provided vlan
+// numbers, tagged in each. In TrunkReplace mode the ports are also removed
+// from every vlan not listed, so the trunk carries exactly the given vlans
+// and the native vlan. The current membership is read from the switch and
+// only the rows that differ are written, in a single request, see apply.
+// Without a native vlan the pvid of the ports is left as is, so in
+// TrunkReplace mode a port whose pvid names a vlan not among numbers is an
+// error, it would be left with a pvid of a vlan it is not in.
+func (c *SwitchControllerSnmp) SetPortTrunk(
+	ports []int, numbers []int, opts TrunkOptions) error {
+
+	return c.stage("SetPortTrunk", func(t *vlanTable) error {
+		return t.setPortTrunk(ports, numbers, opts)
+	})
 
 }
 
 // ClearPorts clears the specified ports of any an all vlans on the switch.
+// A port whose pvid names a vlan it leaves gets the vlan it is still
+// untagged in, or the default vlan 1, as its pvid.
 func (c *SwitchControllerSnmp) ClearPorts(ports []int) error {
 
-	vlans, err := c.GetVlans()
-	if err != nil {
-		return fmt.Errorf("ClearPort: GetVlans failed: %v", err)
-	}
-
-	for _, v := range vlans {
-		egress_clear := false
-		access_clear := false
-		for _, p := range ports {
-			if IsPortSet(p-1, v.EgressPorts) {
-				UnsetPort(p-1, v.EgressPorts)
-				egress_clear = true
-			}
-			if IsPortSet(p-1, v.AccessPorts) {
-				UnsetPort(p-1, v.AccessPorts)
-				access_clear = true
-			}
-		}
-		if egress_clear {
-			setOctetString(c.Snmp, vlanEgressOid(v.Index), v.EgressPorts)
-		}
-		if access_clear {
-			setOctetString(c.Snmp, vlanAccessOid(v.Index), v.AccessPorts)
-		}
-	}
-
-	return nil
+	return c.stage("ClearPorts", func(t *vlanTable) error {
+		return t.clearPorts(ports)
+	})
 
 }
 
 // ClearVlans clears the specified vlans from any and all ports on the switch.
+// Pvids move as for ClearPorts.
 func (c *SwitchControllerSnmp) ClearVlans(vids []int) error {
-	vlans, err := c.GetVlans()
-	if err != nil {
-		return fmt.Errorf("ClearPort: GetVlans failed: %v", err)
-	}
 
-	for _, x := range vids {
-		for _, v := range vlans {
-			if v.Index == x {
-				for i, _ := range v.EgressPorts {
-					v.EgressPorts[i] = 0
-				}
-				for i, _ := range v.AccessPorts {
-					v.AccessPorts[i] = 0
-				}
-				setOctetString(c.Snmp, vlanEgressOid(v.Index), v.EgressPorts)
-				setOctetString(c.Snmp, vlanAccessOid(v.Index), v.AccessPorts)
-			}
-		}
-	}
+	return c.stage("ClearVlans", func(t *vlanTable) error {
+		return t.clearVlans(vids)
+	})
 
-	return nil
 }
 
-// ClearPortVlans clears the specified vlans from the specified port
+// ClearPortVlans clears the specified vlans from the specified port. Its
+// pvid moves as for ClearPorts.
 func (c *SwitchControllerSnmp) ClearPortVlans(port int, targets []int) error {
-	vlans, err := c.GetVlans()
-	if err != nil {
-		return fmt.Errorf("ClearPortVlans: GetVlans failed: %v", err)
-	}
 
-	for _, t := range targets {
-		for _, v := range vlans {
-			if v.Index != t {
-				continue
-			}
-			UnsetPort(port-1, v.EgressPorts)
-			UnsetPort(port-1, v.AccessPorts)
-			setOctetString(c.Snmp, vlanEgressOid(v.Index), v.EgressPorts)
-			setOctetString(c.Snmp, vlanAccessOid(v.Index), v.AccessPorts)
-		}
-	}
+	return c.stage("ClearPortVlans", func(t *vlanTable) error {
+		return t.clearPortVlans(port, targets)
+	})
 
-	return nil
 }
 
+// ClearVlanPorts clears the specified ports from the specified vlan. Pvids
+// move as for ClearPorts.
 func (c *SwitchControllerSnmp) ClearVlanPorts(vid int, ports []int) error {
-	vlans, err := c.GetVlans()
+
+	return c.stage("ClearVlanPorts", func(t *vlanTable) error {
+		return t.clearVlanPorts(vid, ports)
+	})
+
+}
+
+// stage reads the vlan table, applies op to it and writes the resulting
+// changes back to the switch.
+func (c *SwitchControllerSnmp) stage(name string, op func(*vlanTable) error) error {
+
+	t, err := c.vlanTable()
 	if err != nil {
-		return fmt.Errorf("ClearVlanPorts: GetVlans failed: %v", err)
+		return fmt.Errorf("%s: GetVlans failed: %v", name, err)
 	}
-
-	for _, v := range vlans {
-		if v.Index != vid {
-			continue
-		}
-		for _, port := range ports {
-			UnsetPort(port-1, v.EgressPorts)
-			UnsetPort(port-1, v.AccessPorts)
-			setOctetString(c.Snmp, vlanEgressOid(v.Index), v.EgressPorts)
-			setOctetString(c.Snmp, vlanAccessOid(v.Index), v.AccessPorts)
-		}
+	err = op(t)
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return c.apply(t.changes())
 
 }
 
@@ -548,8 +445,19 @@ func (c *SwitchControllerSnmp) ClearVlanPorts(vid int, ports []int) error {
 type Interface struct {
 	Label                                           string
 	Index, BridgeIndex, Kind, AdminStatus, OpStatus int
+
+	// the port vlan settings of a bridge port from dot1qPortVlanTable, these
+	// are zero for interfaces that are not bridge ports
+	Pvid, AcceptableFrameTypes int
+	IngressFiltering           bool
 }
 
+// Values of Interface.AcceptableFrameTypes
+const (
+	AdmitAll            = 1
+	AdmitOnlyVlanTagged = 2
+)
+
 // A Vlan represents an 802.1Q virtual lan bridge object on a switch
 type Vlan struct {
 	Index                    int
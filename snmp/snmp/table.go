@@ -0,0 +1,319 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Table Reader
+ * ====================================---------------
+ *
+ * The code here reads snmp tables column by column and joins the cells into
+ * rows by the index that follows the column oid. Agents leave cells out of
+ * sparse tables, ifAlias being a common one, so the position of a cell in a
+ * column walk says nothing about the row it belongs to.
+ *
+ * The columns of a table are walked together, each GETBULK request asks for
+ * the next cells of several columns at once, so reading a table of n columns
+ * takes about as many round trips as reading one. The max-repetitions of the
+ * requests and the number of sessions the columns are spread over can be
+ * tuned through Options.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"fmt"
+	"github.com/soniah/gosnmp"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A tableRow holds the cells of one row of an snmp table. The index is the
+// oid suffix that names the row, the cells are keyed by column oid.
+type tableRow struct {
+	index []int
+	cells map[string]gosnmp.SnmpPDU
+}
+
+// defaultMaxRepetitions is the GETBULK max-repetitions used for table walks
+// when Options.MaxRepetitions is not set.
+const defaultMaxRepetitions = 25
+
+// walkTable reads the provided columns and joins their cells into rows by
+// index. The columns may come from different tables that share an index, for
+// example ifTable and ifXTable. The rows are returned in index order, a row
+// is present if any of its cells is. The columns are read together, several
+// per GETBULK request, spread over Options.Concurrency sessions.
+func (c *SwitchControllerSnmp) walkTable(columns ...string) ([]tableRow, error) {
+
+	n := c.options().Concurrency
+	if n < 1 {
+		n = 1
+	}
+	if n > len(columns) {
+		n = len(columns)
+	}
+	agents, err := c.sessions(n)
+	if err != nil {
+		return nil, err
+	}
+	n = len(agents)
+
+	groups := make([][]string, n)
+	for i, col := range columns {
+		col = "." + strings.TrimPrefix(col, ".")
+		groups[i%n] = append(groups[i%n], col)
+	}
+
+	rows := make(map[string]*tableRow)
+	var mu sync.Mutex
+	add := func(col string, v gosnmp.SnmpPDU) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return addCell(rows, col, v)
+	}
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range groups {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = bulkWalk(
+				agents[i], groups[i], c.maxRepetitions(), c.maxOids(), add)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sortRows(rows), nil
+
+}
+
+// maxRepetitions returns the GETBULK max-repetitions for table walks.
+func (c *SwitchControllerSnmp) maxRepetitions() int {
+
+	max := c.options().MaxRepetitions
+	if max > 0 && max <= math.MaxUint8 {
+		return max
+	}
+	return defaultMaxRepetitions
+
+}
+
+// bulkWalk walks the subtrees at columns together, asking for the next
+// maxRepetitions cells of up to maxOids columns in each GETBULK request, and
+// calls f with every cell in order. A column is done once the agent returns
+// an oid past its subtree.
+func bulkWalk(
+	snmp Agent,
+	columns []string,
+	maxRepetitions, maxOids int,
+	f func(col string, v gosnmp.SnmpPDU) error) error {
+
+	cursor := make(map[string]string)
+	active := append([]string(nil), columns...)
+	for _, col := range active {
+		cursor[col] = col
+	}
+
+	for len(active) > 0 {
+		req := active
+		if len(req) > maxOids {
+			req = req[:maxOids]
+		}
+		oids := make([]string, len(req))
+		for i, col := range req {
+			oids[i] = cursor[col]
+		}
+
+		resp, err := snmp.GetBulk(oids, 0, uint8(maxRepetitions))
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", req[0], err)
+		}
+		if len(resp.Variables) == 0 {
+			return fmt.Errorf("error reading %s: empty response", req[0])
+		}
+
+		// the response holds the cells of each repetition in request order
+		done := make(map[string]bool)
+		for i, v := range resp.Variables {
+			col := req[i%len(req)]
+			if done[col] {
+				continue
+			}
+			name := "." + strings.TrimPrefix(v.Name, ".")
+			if v.Type == gosnmp.EndOfMibView || !strings.HasPrefix(name, col+".") {
+				done[col] = true
+				continue
+			}
+			if !oidLess(cursor[col], name) {
+				return fmt.Errorf("error reading %s: agent returned %s after %s",
+					col, name, cursor[col])
+			}
+			cursor[col] = name
+			err = f(col, v)
+			if err != nil {
+				return err
+			}
+		}
+
+		var next []string
+		for _, col := range active {
+			if !done[col] {
+				next = append(next, col)
+			}
+		}
+		active = next
+	}
+
+	return nil
+
+}
+
+// addCell adds the cell v of the column col to its row in rows.
+func addCell(rows map[string]*tableRow, col string, v gosnmp.SnmpPDU) error {
+
+	switch v.Type {
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return nil
+	}
+
+	name := "." + strings.TrimPrefix(v.Name, ".")
+	if !strings.HasPrefix(name, col+".") {
+		return nil
+	}
+	suffix := name[len(col)+1:]
+
+	r, ok := rows[suffix]
+	if !ok {
+		index, err := parseIndex(suffix)
+		if err != nil {
+			return fmt.Errorf("bad index in %s: %v", v.Name, err)
+		}
+		r = &tableRow{index: index, cells: make(map[string]gosnmp.SnmpPDU)}
+		rows[suffix] = r
+	}
+	r.cells[col] = v
+	return nil
+
+}
+
+// sortRows returns the rows in index order.
+func sortRows(rows map[string]*tableRow) []tableRow {
+
+	result := make([]tableRow, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, *r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return indexLess(result[i].index, result[j].index)
+	})
+	return result
+
+}
+
+// parseIndex splits an oid index suffix into its sub-identifiers.
+func parseIndex(suffix string) ([]int, error) {
+
+	parts := strings.Split(suffix, ".")
+	index := make([]int, len(parts))
+	for i, p := range parts {
+		x, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, err
+		}
+		index[i] = x
+	}
+	return index, nil
+
+}
+
+// oidLess returns whether the oid a comes before b in lexicographic order.
+func oidLess(a, b string) bool {
+
+	x, err := parseIndex(strings.Trim(a, "."))
+	if err != nil {
+		return false
+	}
+	y, err := parseIndex(strings.Trim(b, "."))
+	if err != nil {
+		return false
+	}
+	return indexLess(x, y)
+
+}
+
+// indexLess orders indices the way an agent orders rows.
+func indexLess(a, b []int) bool {
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+
+}
+
+// last returns the last sub-identifier of the row index. For tables indexed
+// by a TimeMark and a key, such as dot1qVlanCurrentTable, this is the key.
+func (r tableRow) last() int {
+
+	return r.index[len(r.index)-1]
+
+}
+
+// has returns whether the row has a cell in column col.
+func (r tableRow) has(col string) bool {
+
+	_, ok := r.cell(col)
+	return ok
+
+}
+
+// integer returns the value of an integer valued cell, or 0 if the cell is
+// not present.
+func (r tableRow) integer(col string) int {
+
+	v, ok := r.cell(col)
+	if !ok {
+		return 0
+	}
+	x := pduInt(v)
+	if x < 0 && v.Type != gosnmp.Integer {
+		return 0
+	}
+	return x
+
+}
+
+// octets returns the value of an octet string cell, or nil if the cell is
+// not present.
+func (r tableRow) octets(col string) []byte {
+
+	v, ok := r.cell(col)
+	if !ok {
+		return nil
+	}
+	b, _ := v.Value.([]byte)
+	return b
+
+}
+
+// str returns the value of an octet string cell as a string.
+func (r tableRow) str(col string) string {
+
+	return string(r.octets(col))
+
+}
+
+func (r tableRow) cell(col string) (gosnmp.SnmpPDU, bool) {
+
+	v, ok := r.cells["."+strings.TrimPrefix(col, ".")]
+	return v, ok
+
+}
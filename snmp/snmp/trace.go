@@ -0,0 +1,110 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Request Tracing
+ * ====================================------------------
+ *
+ * The code here logs the requests a controller sends to its switch when
+ * Options.Logger is set, one line per request with the first oid, the
+ * number of varbinds each way and the time it took. It is meant for finding
+ * out why a command is slow or which object an agent refuses.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"github.com/soniah/gosnmp"
+	"log"
+	"time"
+)
+
+// A tracingAgent is an Agent that logs every request it passes on.
+type tracingAgent struct {
+	Agent
+	log *log.Logger
+}
+
+// traced returns agent wrapped to log its requests if Options.Logger is set,
+// agent itself otherwise.
+func traced(agent Agent, opts *Options) Agent {
+
+	if opts.Logger == nil {
+		return agent
+	}
+	return &tracingAgent{Agent: agent, log: opts.Logger}
+
+}
+
+// untraced returns the agent a tracingAgent wraps.
+func untraced(agent Agent) Agent {
+
+	if t, ok := agent.(*tracingAgent); ok {
+		return t.Agent
+	}
+	return agent
+
+}
+
+func (t *tracingAgent) Get(oids []string) (*gosnmp.SnmpPacket, error) {
+
+	start := time.Now()
+	resp, err := t.Agent.Get(oids)
+	t.trace("get", oidsFirst(oids), len(oids), resp, err, start)
+	return resp, err
+
+}
+
+func (t *tracingAgent) GetBulk(
+	oids []string,
+	nonRepeaters uint8,
+	maxRepetitions uint8) (*gosnmp.SnmpPacket, error) {
+
+	start := time.Now()
+	resp, err := t.Agent.GetBulk(oids, nonRepeaters, maxRepetitions)
+	t.trace("getbulk", oidsFirst(oids), len(oids), resp, err, start)
+	return resp, err
+
+}
+
+func (t *tracingAgent) Set(pdus []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error) {
+
+	first := ""
+	if len(pdus) > 0 {
+		first = pdus[0].Name
+	}
+	start := time.Now()
+	resp, err := t.Agent.Set(pdus)
+	t.trace("set", first, len(pdus), resp, err, start)
+	return resp, err
+
+}
+
+func (t *tracingAgent) trace(
+	op, first string,
+	n int,
+	resp *gosnmp.SnmpPacket,
+	err error,
+	start time.Time) {
+
+	elapsed := time.Since(start).Round(time.Microsecond)
+	if err != nil {
+		t.log.Printf("snmp %s %s (%d oids) failed after %v: %v",
+			op, first, n, elapsed, err)
+		return
+	}
+	got := 0
+	if resp != nil {
+		got = len(resp.Variables)
+	}
+	t.log.Printf("snmp %s %s (%d oids) -> %d varbinds in %v",
+		op, first, n, got, elapsed)
+
+}
+
+func oidsFirst(oids []string) string {
+
+	if len(oids) == 0 {
+		return ""
+	}
+	return oids[0]
+
+}
@@ -0,0 +1,186 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Transactions
+ * ====================================---------------
+ *
+ * The code here lets a caller stage a set of vlan changes and apply them as
+ * a unit. On commit the affected rows of the static vlan table and port vlan
+ * table are snapshotted, all the staged changes are written, and if any
+ * write fails the rows that may have been changed are restored from the
+ * snapshot.
+ *
+ *	tx := c.Begin()
+ *	tx.SetPortTrunk([]int{1, 2}, []int{101, 201}, snmp.TrunkOptions{})
+ *	tx.SetPortAccess([]int{3}, 47)
+ *	result, err := tx.Commit()
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"fmt"
+)
+
+// A Transaction is a set of staged vlan changes for a switch. Nothing is
+// sent to the switch until Commit is called. A Transaction must not be
+// committed more than once.
+type Transaction struct {
+	c   *SwitchControllerSnmp
+	ops []func(*vlanTable) error
+}
+
+// A CommitResult describes what a commit did to the static vlan table and
+// the port vlan table. Vlan rows are named by vid and port rows by bridge
+// port.
+type CommitResult struct {
+	// Changed lists the rows that were written. On failure these are the
+	// rows that may have been modified before the failure.
+	Changed, ChangedPorts []int
+
+	// RolledBack lists the rows that were restored from the snapshot after a
+	// failure.
+	RolledBack, RolledBackPorts []int
+
+	// RollbackError is set when restoring the snapshot failed, in which case
+	// the rows in Changed that are not in RolledBack are in an unknown state.
+	RollbackError error
+}
+
+// Begin starts a new transaction on the switch.
+func (c *SwitchControllerSnmp) Begin() *Transaction {
+
+	return &Transaction{c: c}
+
+}
+
+// SetPortAccess stages SwitchControllerSnmp.SetPortAccess.
+func (tx *Transaction) SetPortAccess(ports []int, vid int) {
+	tx.ops = append(tx.ops, func(t *vlanTable) error {
+		return t.setPortAccess(ports, vid)
+	})
+}
+
+// MovePortAccess stages SwitchControllerSnmp.MovePortAccess.
+func (tx *Transaction) MovePortAccess(ports []int, vid int) {
+	tx.ops = append(tx.ops, func(t *vlanTable) error {
+		return t.movePortAccess(ports, vid)
+	})
+}
+
+// SetPortTrunk stages SwitchControllerSnmp.SetPortTrunk.
+func (tx *Transaction) SetPortTrunk(ports []int, vids []int, opts TrunkOptions) {
+	tx.ops = append(tx.ops, func(t *vlanTable) error {
+		return t.setPortTrunk(ports, vids, opts)
+	})
+}
+
+// ClearPorts stages SwitchControllerSnmp.ClearPorts.
+func (tx *Transaction) ClearPorts(ports []int) {
+	tx.ops = append(tx.ops, func(t *vlanTable) error {
+		return t.clearPorts(ports)
+	})
+}
+
+// ClearVlans stages SwitchControllerSnmp.ClearVlans.
+func (tx *Transaction) ClearVlans(vids []int) {
+	tx.ops = append(tx.ops, func(t *vlanTable) error {
+		return t.clearVlans(vids)
+	})
+}
+
+// ClearPortVlans stages SwitchControllerSnmp.ClearPortVlans.
+func (tx *Transaction) ClearPortVlans(port int, vids []int) {
+	tx.ops = append(tx.ops, func(t *vlanTable) error {
+		return t.clearPortVlans(port, vids)
+	})
+}
+
+// ClearVlanPorts stages SwitchControllerSnmp.ClearVlanPorts.
+func (tx *Transaction) ClearVlanPorts(vid int, ports []int) {
+	tx.ops = append(tx.ops, func(t *vlanTable) error {
+		return t.clearVlanPorts(vid, ports)
+	})
+}
+
+// Commit applies the staged changes to the switch. If a write fails the
+// rows that may have been modified are restored and the original error is
+// returned along with a result saying which rows were rolled back.
+func (tx *Transaction) Commit() (*CommitResult, error) {
+
+	t, err := tx.c.vlanTable()
+	if err != nil {
+		return nil, fmt.Errorf("Commit: GetVlans failed: %v", err)
+	}
+	for _, op := range tx.ops {
+		err = op(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cs := t.changes()
+	result := &CommitResult{}
+	changed, err := tx.c.applyChanges(cs)
+	result.Changed, result.ChangedPorts = changed.vlans, changed.ports
+	if err == nil {
+		// a verification failure is reported but not rolled back, the
+		// switch accepted the change and normalized it
+		return result, tx.c.verifyChanges(cs)
+	}
+	if len(changed.vlans) == 0 && len(changed.ports) == 0 {
+		return result, err
+	}
+
+	restored, rerr := tx.c.rollback(t, changed)
+	result.RolledBack, result.RolledBackPorts = restored.vlans, restored.ports
+	result.RollbackError = rerr
+	return result, err
+
+}
+
+// rollback restores the provided rows from the snapshot held in t,
+// returning the rows that were restored.
+func (c *SwitchControllerSnmp) rollback(t *vlanTable, rows rowSet) (rowSet, error) {
+
+	var cs changeset
+	var created []int
+	for _, vid := range rows.vlans {
+		o, existed := t.orig[vid]
+		if !existed {
+			created = append(created, vid)
+			continue
+		}
+		// the switch is most likely in the working state, order the writes
+		// as a transition from it
+		cs.vlans = append(cs.vlans, vlanUpdate{
+			vid:          vid,
+			egress:       o.EgressPorts,
+			untagged:     o.AccessPorts,
+			prevEgress:   t.vlans[vid].EgressPorts,
+			prevUntagged: t.vlans[vid].AccessPorts,
+		})
+	}
+	for _, port := range rows.ports {
+		pvid, ok := t.origPvids[port]
+		if ok {
+			cs.pvids = append(cs.pvids, pvidUpdate{port, pvid})
+		}
+	}
+
+	restored, err := c.applyChanges(cs)
+	if err != nil {
+		return rowSet{}, fmt.Errorf("rollback: %v", err)
+	}
+
+	// vlans that did not exist before the commit are removed again
+	for _, vid := range created {
+		err = c.DeleteVlan(vid)
+		if err != nil {
+			return restored, fmt.Errorf("rollback: deleting vlan %d: %v", vid, err)
+		}
+		restored.vlans = append(restored.vlans, vid)
+	}
+
+	return restored, nil
+
+}
@@ -0,0 +1,80 @@
+package snmp
+
+import (
+	"reflect"
+	"testing"
+)
+
+// A failed commit restores the rows written before the failure.
+func TestCommitRollback(t *testing.T) {
+
+	c, a := loadSetLog(t, &Options{Quirks: []string{QuirkNoMultiSet}})
+	vlans, err := c.GetVlans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pvids, err := c.getPvids()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the vlan 10 writes and the pvid go through, vlan 1 fails
+	a.failAt = 4
+	tx := c.Begin()
+	tx.MovePortAccess([]int{1}, 10)
+	result, err := tx.Commit()
+	if err == nil {
+		t.Fatal("commit did not fail")
+	}
+	if result.RollbackError != nil {
+		t.Fatal(result.RollbackError)
+	}
+	if !sameInts(result.Changed, []int{10}) ||
+		!sameInts(result.ChangedPorts, []int{1}) {
+		t.Errorf("changed %v ports %v, want [10] [1]",
+			result.Changed, result.ChangedPorts)
+	}
+	if !sameInts(result.RolledBack, []int{10}) ||
+		!sameInts(result.RolledBackPorts, []int{1}) {
+		t.Errorf("rolled back %v ports %v, want [10] [1]",
+			result.RolledBack, result.RolledBackPorts)
+	}
+
+	after, err := c.GetVlans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, vlans) {
+		t.Errorf("vlans not restored\n got %v\nwant %v", after, vlans)
+	}
+	afterPvids, err := c.getPvids()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(afterPvids, pvids) {
+		t.Errorf("pvids not restored, got %v want %v", afterPvids, pvids)
+	}
+
+}
+
+// A commit the agent refuses as a whole changed nothing and restores nothing.
+func TestCommitRejected(t *testing.T) {
+
+	c, a := loadSetLog(t, &Options{})
+	a.failAt = 1
+	tx := c.Begin()
+	tx.SetPortTrunk([]int{1, 2}, []int{20}, TrunkOptions{})
+	tx.SetPortAccess([]int{3}, 10)
+	result, err := tx.Commit()
+	if err == nil {
+		t.Fatal("commit did not fail")
+	}
+	if len(a.sets) != 1 {
+		t.Errorf("got %d requests, want 1", len(a.sets))
+	}
+	if len(result.Changed) != 0 || len(result.RolledBack) != 0 {
+		t.Errorf("changed %v rolled back %v, want nothing",
+			result.Changed, result.RolledBack)
+	}
+
+}
@@ -0,0 +1,718 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Vlan Membership Updates
+ * ====================================--------------------------
+ *
+ * The code here applies changes to the Q-BRIDGE static vlan table and the
+ * port vlan id (dot1qPvid) of bridge ports. All the writes that make up a
+ * change are sent in a single SET request so the agent applies them
+ * atomically. When the agent cannot take the change in one request (too
+ * many varbinds, a tooBig response, or the no-multi-set quirk) the writes
+ * are sent one at a time in the following order, which keeps every
+ * intermediate state valid under RFC 4363 (the untagged list of a vlan is
+ * always a subset of its egress list, and a port is a member of the vlan
+ * its pvid names)
+ *
+ *	- vlans that only gain ports, in the order the change lists them
+ *	- port vlan ids
+ *	- vlans that lose ports, in the order the change lists them
+ *
+ * and within a vlan
+ *
+ *	- if it only gains egress ports: egress, then untagged
+ *	- if it only loses egress ports: untagged, then egress
+ *	- if it does both: egress (old and new ports), untagged, egress (new)
+ *
+ * The operations that take ports out of vlans move the pvid of a port that
+ * leaves the vlan it names to the vlan the port is still untagged in, or to
+ * the default vlan 1 when there is none. A port in no vlan but 1 is the one
+ * case where the pvid names a vlan the port is not a member of, as it is on
+ * a switch fresh from the factory.
+ *
+ * If a write in the ordered sequence fails the writes before it remain
+ * applied and a *StepError says which step failed.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/soniah/gosnmp"
+	"math"
+	"sort"
+)
+
+// QuirkNoMultiSet marks agents that reject or mishandle SET requests with
+// more than one varbind. Changes to such agents are always sent as the
+// ordered sequence of single writes.
+const QuirkNoMultiSet = "no-multi-set"
+
+// defaultVid is the vlan ports are in when nothing else says, the pvid given
+// to ports that leave every vlan they are untagged in.
+const defaultVid = 1
+
+// A changeset is the set of writes that bring the switch to a new state.
+type changeset struct {
+	vlans []vlanUpdate
+	pvids []pvidUpdate
+}
+
+// A vlanUpdate is a change to the static membership of a vlan. Nil portlists
+// are left unchanged on the switch.
+type vlanUpdate struct {
+	vid              int
+	egress, untagged []byte
+
+	// the lists currently on the switch, used to order the writes of the
+	// fallback sequence
+	prevEgress, prevUntagged []byte
+}
+
+// A pvidUpdate is a change to the port vlan id of a bridge port.
+type pvidUpdate struct {
+	port, pvid int
+}
+
+// A rowSet names rows of the static vlan table, by vid, and of the port vlan
+// table, by bridge port.
+type rowSet struct {
+	vlans, ports []int
+}
+
+func (u pvidUpdate) pdu() gosnmp.SnmpPDU {
+
+	return gosnmp.SnmpPDU{
+		Name:  pvidOid(u.port),
+		Type:  gosnmp.Gauge32,
+		Value: uint(u.pvid),
+	}
+
+}
+
+// shrinks returns whether u removes any port from the vlan.
+func (u vlanUpdate) shrinks() bool {
+
+	return u.egress != nil && portlistMissing(u.prevEgress, u.egress) ||
+		u.untagged != nil && portlistMissing(u.prevUntagged, u.untagged)
+
+}
+
+// atomicPdus returns the writes for u as they appear in a single request.
+func (u vlanUpdate) atomicPdus() []gosnmp.SnmpPDU {
+
+	var pdus []gosnmp.SnmpPDU
+	if u.egress != nil {
+		pdus = append(pdus, octetStringPdu(vlanEgressOid(u.vid), u.egress))
+	}
+	if u.untagged != nil {
+		pdus = append(pdus, octetStringPdu(vlanAccessOid(u.vid), u.untagged))
+	}
+	return pdus
+
+}
+
+// orderedPdus returns the writes for u in an order that can be applied one at
+// a time, see the file header.
+func (u vlanUpdate) orderedPdus() []gosnmp.SnmpPDU {
+
+	if u.egress == nil || u.untagged == nil {
+		return u.atomicPdus()
+	}
+
+	egress := octetStringPdu(vlanEgressOid(u.vid), u.egress)
+	untagged := octetStringPdu(vlanAccessOid(u.vid), u.untagged)
+
+	grows := portlistMissing(u.egress, u.prevEgress)
+	shrinks := portlistMissing(u.prevEgress, u.egress)
+
+	switch {
+	case !shrinks:
+		return []gosnmp.SnmpPDU{egress, untagged}
+	case !grows:
+		return []gosnmp.SnmpPDU{untagged, egress}
+	}
+
+	union := octetStringPdu(vlanEgressOid(u.vid), portlistOr(u.egress, u.prevEgress))
+	return []gosnmp.SnmpPDU{union, untagged, egress}
+
+}
+
+// apply writes the provided changes to the switch, atomically if the agent
+// allows it and as the ordered sequence otherwise. When verification is
+// enabled the written objects are then read back and checked.
+func (c *SwitchControllerSnmp) apply(cs changeset) error {
+
+	_, err := c.applyChanges(cs)
+	if err != nil {
+		return err
+	}
+	return c.verifyChanges(cs)
+
+}
+
+// verifyChanges checks that the switch holds the state written by cs, if
+// verification is enabled.
+func (c *SwitchControllerSnmp) verifyChanges(cs changeset) error {
+
+	if !c.verifying() {
+		return nil
+	}
+	return c.verify(cs.atomicPdus())
+
+}
+
+// atomicPdus returns all the writes of cs as they appear in a single request.
+func (cs changeset) atomicPdus() []gosnmp.SnmpPDU {
+
+	var pdus []gosnmp.SnmpPDU
+	for _, u := range cs.vlans {
+		pdus = append(pdus, u.atomicPdus()...)
+	}
+	for _, u := range cs.pvids {
+		pdus = append(pdus, u.pdu())
+	}
+	return pdus
+
+}
+
+// applyChanges is apply that also reports the rows that may have been
+// changed on the switch, which on failure are the ones that need restoring.
+func (c *SwitchControllerSnmp) applyChanges(cs changeset) (rowSet, error) {
+
+	var all rowSet
+	for _, u := range cs.vlans {
+		if len(u.atomicPdus()) > 0 {
+			all.vlans = appendVid(all.vlans, u.vid)
+		}
+	}
+	for _, u := range cs.pvids {
+		all.ports = appendVid(all.ports, u.port)
+	}
+
+	atomic := cs.atomicPdus()
+	if len(atomic) == 0 {
+		return rowSet{}, nil
+	}
+
+	// the ordered sequence, and the row each of its writes belongs to
+	var ordered []gosnmp.SnmpPDU
+	var rows []rowSet
+	vlanPdus := func(shrinking bool) {
+		for _, u := range cs.vlans {
+			if u.shrinks() != shrinking {
+				continue
+			}
+			for _, pdu := range u.orderedPdus() {
+				ordered = append(ordered, pdu)
+				rows = append(rows, rowSet{vlans: []int{u.vid}})
+			}
+		}
+	}
+	vlanPdus(false)
+	for _, u := range cs.pvids {
+		ordered = append(ordered, u.pdu())
+		rows = append(rows, rowSet{ports: []int{u.port}})
+	}
+	vlanPdus(true)
+
+	limit := c.maxOids()
+	multi := !c.options().HasQuirk(QuirkNoMultiSet)
+	if len(atomic) == 1 || multi && len(atomic) <= limit {
+		err := set(c.client(), atomic)
+		if err == nil {
+			return all, nil
+		}
+		if _, rejected := err.(*SetError); rejected &&
+			(!IsSetError(err, gosnmp.TooBig) || len(atomic) == 1) {
+			// the agent refused the request as a whole, nothing changed
+			return rowSet{}, err
+		}
+		if !IsSetError(err, gosnmp.TooBig) {
+			// no response, the outcome is unknown
+			return all, err
+		}
+	}
+
+	err := setSequence(c.client(), ordered)
+	if err == nil {
+		return all, nil
+	}
+	step := err.(*StepError)
+	last := step.Step - 1
+	if _, rejected := step.Err.(*SetError); !rejected {
+		last = step.Step
+	}
+	var touched rowSet
+	for _, r := range rows[:last] {
+		for _, vid := range r.vlans {
+			touched.vlans = appendVid(touched.vlans, vid)
+		}
+		for _, port := range r.ports {
+			touched.ports = appendVid(touched.ports, port)
+		}
+	}
+	return touched, err
+
+}
+
+// A vlanTable is an in memory copy of the static vlan table that changes are
+// staged against. It remembers the rows as they were read so that only the
+// differences are written back and so that they can be restored.
+type vlanTable struct {
+	vlans map[int]*Vlan
+	orig  map[int]Vlan
+	order []int // vids in the order they were first modified
+	size  int   // portlist length in octets for new vlans
+	c     *SwitchControllerSnmp
+
+	// port vlan ids by bridge port
+	pvids, origPvids map[int]int
+}
+
+// vlanTable reads the static vlan table of the switch.
+func (c *SwitchControllerSnmp) vlanTable() (*vlanTable, error) {
+
+	vlans, err := c.GetVlans()
+	if err != nil {
+		return nil, err
+	}
+
+	pvids, err := c.getPvids()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &vlanTable{
+		vlans:     make(map[int]*Vlan),
+		orig:      make(map[int]Vlan),
+		c:         c,
+		pvids:     make(map[int]int),
+		origPvids: pvids,
+	}
+
+	// agents leave out the cells of empty portlists and may send them short,
+	// the operations work on lists of the full length
+	size, err := t.portlistSize()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range vlans {
+		if len(v.EgressPorts) > size {
+			size = len(v.EgressPorts)
+		}
+		if len(v.AccessPorts) > size {
+			size = len(v.AccessPorts)
+		}
+	}
+	t.size = size
+
+	for _, v := range vlans {
+		v.EgressPorts = portlistPad(v.EgressPorts, size)
+		v.AccessPorts = portlistPad(v.AccessPorts, size)
+		t.orig[v.Index] = v.clone()
+		x := v.clone()
+		t.vlans[v.Index] = &x
+	}
+	for port, pvid := range pvids {
+		t.pvids[port] = pvid
+	}
+
+	return t, nil
+
+}
+
+// vlan returns the working copy of a vlan for modification. When the vlan
+// does not exist and create is set an empty one is added, otherwise nil is
+// returned.
+func (t *vlanTable) vlan(vid int, create bool) (*Vlan, error) {
+
+	v, ok := t.vlans[vid]
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		size, err := t.portlistSize()
+		if err != nil {
+			return nil, err
+		}
+		v = &Vlan{
+			Index:       vid,
+			EgressPorts: make([]byte, size),
+			AccessPorts: make([]byte, size),
+		}
+		t.vlans[vid] = v
+	}
+	t.order = appendVid(t.order, vid)
+	return v, nil
+
+}
+
+// each calls f with the working copy of every vlan in the table.
+func (t *vlanTable) each(f func(v *Vlan)) {
+
+	var vids []int
+	for vid := range t.vlans {
+		vids = append(vids, vid)
+	}
+	sort.Ints(vids)
+	for _, vid := range vids {
+		v, _ := t.vlan(vid, false)
+		f(v)
+	}
+
+}
+
+// portlistSize returns the length of the portlists on the switch, from the
+// number of bridge ports.
+func (t *vlanTable) portlistSize() (int, error) {
+
+	if t.size == 0 {
+		bridge_size, err := getCounter(t.c.client(), ".1.3.6.1.2.1.17.1.2.0")
+		if err != nil {
+			return 0, err
+		}
+		t.size = int(math.Ceil(float64(bridge_size) / 8.0))
+	}
+	return t.size, nil
+
+}
+
+// checkPorts returns an error if any of ports is outside the portlists of
+// the switch.
+func (t *vlanTable) checkPorts(ports ...int) error {
+
+	size, err := t.portlistSize()
+	if err != nil {
+		return err
+	}
+	for _, p := range ports {
+		if p < 1 || p > size*8 {
+			return fmt.Errorf("bridge port %d out of range, the portlists "+
+				"of the switch hold ports 1 to %d", p, size*8)
+		}
+	}
+	return nil
+
+}
+
+// changes returns the changes needed to bring the switch to the working state
+// of the table.
+func (t *vlanTable) changes() changeset {
+
+	var result changeset
+	for _, vid := range t.order {
+		v := t.vlans[vid]
+		o, existed := t.orig[vid]
+		u := vlanUpdate{
+			vid:          vid,
+			prevEgress:   o.EgressPorts,
+			prevUntagged: o.AccessPorts,
+		}
+		if !existed {
+			u.prevEgress = make([]byte, len(v.EgressPorts))
+			u.prevUntagged = make([]byte, len(v.AccessPorts))
+			u.egress = v.EgressPorts
+			if !portlistEmpty(v.AccessPorts) {
+				u.untagged = v.AccessPorts
+			}
+		} else {
+			if !bytes.Equal(v.EgressPorts, o.EgressPorts) {
+				u.egress = v.EgressPorts
+			}
+			if !bytes.Equal(v.AccessPorts, o.AccessPorts) {
+				u.untagged = v.AccessPorts
+			}
+		}
+		if u.egress != nil || u.untagged != nil {
+			result.vlans = append(result.vlans, u)
+		}
+	}
+
+	var ports []int
+	for port, pvid := range t.pvids {
+		if t.origPvids[port] != pvid {
+			ports = append(ports, port)
+		}
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		result.pvids = append(result.pvids, pvidUpdate{port, t.pvids[port]})
+	}
+
+	return result
+
+}
+
+// appendVid appends vid to vids if it is not already there.
+func appendVid(vids []int, vid int) []int {
+
+	for _, x := range vids {
+		if x == vid {
+			return vids
+		}
+	}
+	return append(vids, vid)
+
+}
+
+func (v Vlan) clone() Vlan {
+
+	v.EgressPorts = append([]byte(nil), v.EgressPorts...)
+	v.AccessPorts = append([]byte(nil), v.AccessPorts...)
+	return v
+
+}
+
+///            ----------------------------------------------------------------
+/// Staged operations
+///  --------------------------------------------------------------------------
+
+func (t *vlanTable) setPortAccess(ports []int, vid int) error {
+
+	err := t.checkPorts(ports...)
+	if err != nil {
+		return err
+	}
+	v, err := t.vlan(vid, true)
+	if err != nil {
+		return err
+	}
+	for _, p := range ports {
+		SetPort(p-1, v.EgressPorts)
+		SetPort(p-1, v.AccessPorts)
+		t.pvids[p] = vid
+	}
+	return nil
+
+}
+
+func (t *vlanTable) movePortAccess(ports []int, vid int) error {
+
+	err := t.checkPorts(ports...)
+	if err != nil {
+		return err
+	}
+	t.each(func(v *Vlan) {
+		if v.Index == vid {
+			return
+		}
+		for _, p := range ports {
+			UnsetPort(p-1, v.EgressPorts)
+			UnsetPort(p-1, v.AccessPorts)
+		}
+	})
+	return t.setPortAccess(ports, vid)
+
+}
+
+// tagPorts adds ports to vids tagged.
+func (t *vlanTable) tagPorts(ports []int, vids []int) error {
+
+	err := t.checkPorts(ports...)
+	if err != nil {
+		return err
+	}
+	for _, vid := range vids {
+		v, err := t.vlan(vid, true)
+		if err != nil {
+			return err
+		}
+		for _, p := range ports {
+			SetPort(p-1, v.EgressPorts)
+		}
+	}
+	return nil
+
+}
+
+func (t *vlanTable) setPortTrunkNative(ports []int, vids []int, native int) error {
+
+	err := t.checkPorts(ports...)
+	if err != nil {
+		return err
+	}
+	if native == 0 {
+		return t.tagPorts(ports, vids)
+	}
+
+	// the port is untagged only in the native vlan, former access vlans that
+	// are not part of the trunk are dropped
+	tagged := make(map[int]bool)
+	for _, vid := range vids {
+		tagged[vid] = true
+	}
+	t.each(func(v *Vlan) {
+		if v.Index == native {
+			return
+		}
+		for _, p := range ports {
+			if !IsPortSet(p-1, v.AccessPorts) {
+				continue
+			}
+			UnsetPort(p-1, v.AccessPorts)
+			if !tagged[v.Index] {
+				UnsetPort(p-1, v.EgressPorts)
+			}
+		}
+	})
+
+	err = t.tagPorts(ports, vids)
+	if err != nil {
+		return err
+	}
+	return t.setPortAccess(ports, native)
+
+}
+
+func (t *vlanTable) setPortTrunk(
+	ports []int, vids []int, opts TrunkOptions) error {
+
+	err := t.checkPorts(ports...)
+	if err != nil {
+		return err
+	}
+	native := opts.Native
+	if opts.Mode == TrunkReplace {
+		allowed := make(map[int]bool)
+		for _, vid := range vids {
+			allowed[vid] = true
+		}
+
+		// without a native vlan the pvid is left as is, so the trunk must
+		// keep the vlan it names
+		for _, p := range ports {
+			pvid := t.pvids[p]
+			v, ok := t.vlans[pvid]
+			if native == 0 && !allowed[pvid] && ok &&
+				IsPortSet(p-1, v.EgressPorts) {
+				return fmt.Errorf("bridge port %d has pvid %d which the trunk "+
+					"does not carry, a native vlan is required", p, pvid)
+			}
+		}
+
+		t.each(func(v *Vlan) {
+			for _, p := range ports {
+				switch {
+				case v.Index == native:
+				case allowed[v.Index]:
+					UnsetPort(p-1, v.AccessPorts)
+				default:
+					UnsetPort(p-1, v.EgressPorts)
+					UnsetPort(p-1, v.AccessPorts)
+				}
+			}
+		})
+	}
+
+	return t.setPortTrunkNative(ports, vids, native)
+
+}
+
+func (t *vlanTable) clearPorts(ports []int) error {
+
+	err := t.checkPorts(ports...)
+	if err != nil {
+		return err
+	}
+	t.each(func(v *Vlan) {
+		for _, p := range ports {
+			UnsetPort(p-1, v.EgressPorts)
+			UnsetPort(p-1, v.AccessPorts)
+		}
+	})
+	t.movePvids(ports...)
+	return nil
+
+}
+
+func (t *vlanTable) clearVlans(vids []int) error {
+
+	cleared := make(map[int]bool)
+	for _, vid := range vids {
+		v, _ := t.vlan(vid, false)
+		if v == nil {
+			continue
+		}
+		v.EgressPorts = make([]byte, len(v.EgressPorts))
+		v.AccessPorts = make([]byte, len(v.AccessPorts))
+		cleared[vid] = true
+	}
+	var ports []int
+	for port, pvid := range t.pvids {
+		if cleared[pvid] {
+			ports = append(ports, port)
+		}
+	}
+	t.movePvids(ports...)
+	return nil
+
+}
+
+func (t *vlanTable) clearPortVlans(port int, vids []int) error {
+
+	err := t.checkPorts(port)
+	if err != nil {
+		return err
+	}
+	for _, vid := range vids {
+		v, _ := t.vlan(vid, false)
+		if v == nil {
+			continue
+		}
+		UnsetPort(port-1, v.EgressPorts)
+		UnsetPort(port-1, v.AccessPorts)
+	}
+	t.movePvids(port)
+	return nil
+
+}
+
+func (t *vlanTable) clearVlanPorts(vid int, ports []int) error {
+
+	err := t.checkPorts(ports...)
+	if err != nil {
+		return err
+	}
+	v, _ := t.vlan(vid, false)
+	if v == nil {
+		return nil
+	}
+	for _, p := range ports {
+		UnsetPort(p-1, v.EgressPorts)
+		UnsetPort(p-1, v.AccessPorts)
+	}
+	t.movePvids(ports...)
+	return nil
+
+}
+
+// movePvids moves the pvid of each of ports that is no longer a member of
+// the vlan its pvid names to the first vlan the port is untagged in, or to
+// defaultVid if there is none.
+func (t *vlanTable) movePvids(ports ...int) {
+
+	var vids []int
+	for vid := range t.vlans {
+		vids = append(vids, vid)
+	}
+	sort.Ints(vids)
+
+	for _, p := range ports {
+		pvid, ok := t.pvids[p]
+		if !ok {
+			continue
+		}
+		if v, ok := t.vlans[pvid]; ok && IsPortSet(p-1, v.EgressPorts) {
+			continue
+		}
+		t.pvids[p] = defaultVid
+		for _, vid := range vids {
+			if IsPortSet(p-1, t.vlans[vid].AccessPorts) {
+				t.pvids[p] = vid
+				break
+			}
+		}
+	}
+
+}
@@ -0,0 +1,338 @@
+package snmp
+
+import (
+	"github.com/deter-project/switch-drivers/snmp/internal/snmptest"
+	"github.com/soniah/gosnmp"
+	"testing"
+)
+
+// A setLog is an Agent that logs the SET requests it passes on to a
+// recording and fails the one numbered failAt, counting from 1, with a
+// genErr.
+type setLog struct {
+	*snmptest.Recording
+	sets   [][]gosnmp.SnmpPDU
+	failAt int
+}
+
+func (a *setLog) Set(pdus []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error) {
+
+	a.sets = append(a.sets, pdus)
+	if len(a.sets) == a.failAt {
+		return &gosnmp.SnmpPacket{Error: gosnmp.GenErr, ErrorIndex: 1}, nil
+	}
+	return a.Recording.Set(pdus)
+
+}
+
+// loadSetLog returns a controller for the fixture that logs its writes.
+func loadSetLog(t *testing.T, opts *Options) (*SwitchControllerSnmp, *setLog) {
+
+	rec, err := snmptest.LoadRecording(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &setLog{Recording: rec}
+	return NewSwitchControllerAgent(a, opts), a
+
+}
+
+// the objects written by a MovePortAccess of port 1 to vlan 10, in the
+// order of the ordered sequence
+var moveSequence = []string{
+	vlanEgressOid(10),
+	vlanAccessOid(10),
+	pvidOid(1),
+	vlanAccessOid(1),
+	vlanEgressOid(1),
+}
+
+// vlanPorts returns the egress and untagged ports of vid on the switch.
+func vlanPorts(t *testing.T, c *SwitchControllerSnmp, vid int) ([]int, []int) {
+
+	vlans, err := c.GetVlans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports := func(list []byte) []int {
+		var result []int
+		for i := 0; i < len(list)*8; i++ {
+			if IsPortSet(i, list) {
+				result = append(result, i+1)
+			}
+		}
+		return result
+	}
+	for _, v := range vlans {
+		if v.Index == vid {
+			return ports(v.EgressPorts), ports(v.AccessPorts)
+		}
+	}
+	t.Fatalf("no vlan %d", vid)
+	return nil, nil
+
+}
+
+func sameInts(a, b []int) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+
+}
+
+// Vlan 30 of the fixture has no untagged cell, every operation that walks
+// the vlans must cope with it.
+func TestSparseVlanRow(t *testing.T) {
+
+	ops := []struct {
+		name string
+		op   func(c *SwitchControllerSnmp) error
+	}{
+		{"MovePortAccess", func(c *SwitchControllerSnmp) error {
+			return c.MovePortAccess([]int{8}, 20)
+		}},
+		{"ClearPorts", func(c *SwitchControllerSnmp) error {
+			return c.ClearPorts([]int{8})
+		}},
+		{"SetPortTrunk replace", func(c *SwitchControllerSnmp) error {
+			return c.SetPortTrunk([]int{8}, []int{20},
+				TrunkOptions{Native: 10, Mode: TrunkReplace})
+		}},
+		{"SetPortTrunk native", func(c *SwitchControllerSnmp) error {
+			return c.SetPortTrunk([]int{8}, []int{30}, TrunkOptions{Native: 20})
+		}},
+	}
+	for _, x := range ops {
+		c, _ := loadFixture(t, nil)
+		err := x.op(c)
+		if err != nil {
+			t.Errorf("%s: %v", x.name, err)
+			continue
+		}
+		egress, _ := vlanPorts(t, c, 30)
+		if x.name != "SetPortTrunk native" && len(egress) != 0 {
+			t.Errorf("%s: vlan 30 egress %v, want none", x.name, egress)
+		}
+	}
+
+}
+
+func TestSetPortAccessSparseVlan(t *testing.T) {
+
+	c, _ := loadFixture(t, nil)
+	err := c.SetPortAccess([]int{7}, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	egress, untagged := vlanPorts(t, c, 30)
+	if !sameInts(egress, []int{7, 8}) || !sameInts(untagged, []int{7}) {
+		t.Errorf("vlan 30 egress %v untagged %v, want [7 8] [7]",
+			egress, untagged)
+	}
+
+}
+
+// Ports outside the portlists of the switch are refused, not set in a list
+// too short to hold them.
+func TestPortOutOfRange(t *testing.T) {
+
+	ops := []struct {
+		name string
+		op   func(c *SwitchControllerSnmp, port int) error
+	}{
+		{"SetPortAccess", func(c *SwitchControllerSnmp, port int) error {
+			return c.SetPortAccess([]int{port}, 20)
+		}},
+		{"MovePortAccess", func(c *SwitchControllerSnmp, port int) error {
+			return c.MovePortAccess([]int{port}, 20)
+		}},
+		{"SetPortTrunk", func(c *SwitchControllerSnmp, port int) error {
+			return c.SetPortTrunk([]int{port}, []int{20}, TrunkOptions{})
+		}},
+		{"SetPortTrunk replace", func(c *SwitchControllerSnmp, port int) error {
+			return c.SetPortTrunk([]int{port}, []int{20},
+				TrunkOptions{Native: 10, Mode: TrunkReplace})
+		}},
+		{"ClearPorts", func(c *SwitchControllerSnmp, port int) error {
+			return c.ClearPorts([]int{port})
+		}},
+		{"ClearPortVlans", func(c *SwitchControllerSnmp, port int) error {
+			return c.ClearPortVlans(port, []int{10})
+		}},
+		{"ClearVlanPorts", func(c *SwitchControllerSnmp, port int) error {
+			return c.ClearVlanPorts(10, []int{port})
+		}},
+	}
+	for _, x := range ops {
+		for _, port := range []int{0, 9, 100} {
+			c, _ := loadFixture(t, nil)
+			err := x.op(c, port)
+			if err == nil {
+				t.Errorf("%s port %d: no error", x.name, port)
+			}
+		}
+	}
+
+}
+
+// Replacing the vlans of a trunk without a native vlan keeps the pvid, which
+// must stay a vlan of the port.
+func TestTrunkReplaceKeepsPvid(t *testing.T) {
+
+	// port 5 is untagged in vlan 10 with pvid 10
+	c, _ := loadFixture(t, nil)
+	err := c.SetPortTrunk([]int{5}, []int{20}, TrunkOptions{Mode: TrunkReplace})
+	if err == nil {
+		t.Error("replacing the pvid vlan without a native vlan: no error")
+	}
+	egress, _ := vlanPorts(t, c, 10)
+	if !sameInts(egress, []int{5, 6, 8}) {
+		t.Errorf("vlan 10 egress %v, want it unchanged", egress)
+	}
+
+	// port 8 has pvid 10 and keeps vlan 10 tagged
+	err = c.SetPortTrunk([]int{8}, []int{10, 20}, TrunkOptions{Mode: TrunkReplace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	egress, _ = vlanPorts(t, c, 30)
+	if len(egress) != 0 {
+		t.Errorf("vlan 30 egress %v, want none", egress)
+	}
+
+	// with a native vlan the pvid moves
+	err = c.SetPortTrunk([]int{5}, []int{20},
+		TrunkOptions{Native: 30, Mode: TrunkReplace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pvids, err := c.getPvids()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pvids[5] != 30 {
+		t.Errorf("port 5 pvid %d, want 30", pvids[5])
+	}
+
+}
+
+// Taking a port out of the vlan its pvid names moves the pvid to the vlan
+// the port is still untagged in, or to vlan 1.
+func TestClearMovesPvid(t *testing.T) {
+
+	ops := []struct {
+		name  string
+		op    func(c *SwitchControllerSnmp) error
+		pvids map[int]int
+	}{
+		// port 5 is untagged in vlan 10
+		{"ClearPorts", func(c *SwitchControllerSnmp) error {
+			return c.ClearPorts([]int{5})
+		}, map[int]int{5: 1}},
+		// ports 5, 6 and 8 have pvid 10, 4 keeps pvid 1
+		{"ClearVlans", func(c *SwitchControllerSnmp) error {
+			return c.ClearVlans([]int{10, 1})
+		}, map[int]int{4: 1, 5: 1, 6: 1, 8: 1}},
+		// port 7 is untagged in vlan 20
+		{"ClearPortVlans", func(c *SwitchControllerSnmp) error {
+			return c.ClearPortVlans(7, []int{20})
+		}, map[int]int{7: 1}},
+		// port 8 has pvid 10 and is tagged in 10, 20 and 30
+		{"ClearVlanPorts", func(c *SwitchControllerSnmp) error {
+			return c.ClearVlanPorts(10, []int{8})
+		}, map[int]int{8: 1}},
+		// port 7 untagged in vlans 10 and 20 falls back to 20
+		{"ClearVlanPorts untagged", func(c *SwitchControllerSnmp) error {
+			err := c.SetPortAccess([]int{7}, 10)
+			if err != nil {
+				return err
+			}
+			return c.ClearVlanPorts(10, []int{7})
+		}, map[int]int{7: 20}},
+		// a port that stays in the vlan keeps its pvid
+		{"ClearPortVlans other", func(c *SwitchControllerSnmp) error {
+			return c.ClearPortVlans(8, []int{20, 30})
+		}, map[int]int{8: 10}},
+	}
+	for _, x := range ops {
+		c, _ := loadFixture(t, nil)
+		err := x.op(c)
+		if err != nil {
+			t.Errorf("%s: %v", x.name, err)
+			continue
+		}
+		pvids, err := c.getPvids()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for port, want := range x.pvids {
+			if pvids[port] != want {
+				t.Errorf("%s: port %d pvid %d, want %d",
+					x.name, port, pvids[port], want)
+			}
+		}
+	}
+
+}
+
+func TestApplySingleRequest(t *testing.T) {
+
+	c, a := loadSetLog(t, &Options{})
+	err := c.MovePortAccess([]int{1}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.sets) != 1 || len(a.sets[0]) != len(moveSequence) {
+		t.Fatalf("got %d requests, want one of %d varbinds",
+			len(a.sets), len(moveSequence))
+	}
+
+}
+
+// An agent that takes one varbind per request gets the writes in the order
+// the update.go header describes.
+func TestApplyOrderedSequence(t *testing.T) {
+
+	c, a := loadSetLog(t, &Options{Quirks: []string{QuirkNoMultiSet}})
+	err := c.MovePortAccess([]int{1}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.sets) != len(moveSequence) {
+		t.Fatalf("got %d requests, want %d", len(a.sets), len(moveSequence))
+	}
+	for i, pdus := range a.sets {
+		if len(pdus) != 1 || pdus[0].Name != moveSequence[i] {
+			t.Errorf("write %d is %s, want %s", i+1, pdus[0].Name, moveSequence[i])
+		}
+	}
+
+	egress, untagged := vlanPorts(t, c, 10)
+	if !sameInts(egress, []int{1, 5, 6, 8}) || !sameInts(untagged, []int{1, 5, 6}) {
+		t.Errorf("vlan 10 egress %v untagged %v", egress, untagged)
+	}
+
+}
+
+func TestApplyStepError(t *testing.T) {
+
+	c, a := loadSetLog(t, &Options{Quirks: []string{QuirkNoMultiSet}})
+	a.failAt = 4
+	err := c.MovePortAccess([]int{1}, 10)
+	step, ok := err.(*StepError)
+	if !ok {
+		t.Fatalf("got %v, want a *StepError", err)
+	}
+	if step.Step != 4 || step.Oid != moveSequence[3] ||
+		!IsSetError(err, gosnmp.GenErr) {
+		t.Errorf("got %v", err)
+	}
+
+}
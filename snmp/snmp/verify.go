@@ -0,0 +1,151 @@
+/*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+ *
+ * Deter SNMP Switch Controller Library - Read-back Verification
+ * ====================================-------------------------
+ *
+ * Some agents accept a SET and then normalize the value they store. When
+ * verification is enabled (Options.Verify) every object written by a change
+ * is read back afterwards and compared with the value that was intended.
+ *
+ *~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
+package snmp
+
+import (
+	"fmt"
+	"github.com/soniah/gosnmp"
+	"strings"
+)
+
+// A Mismatch is an object whose value on the switch differs from the value
+// that was written to it. For portlists Missing and Extra hold the ports
+// that should be set but are not and that are set but should not be. For
+// integers (dot1qPvid) Want and Got hold the values.
+type Mismatch struct {
+	Oid            string
+	Missing, Extra []int
+	Want, Got      int
+}
+
+// A VerifyError is returned when read-back verification finds that the switch
+// does not hold the intended state.
+type VerifyError struct {
+	Mismatches []Mismatch
+}
+
+func (e *VerifyError) Error() string {
+
+	var s []string
+	for _, m := range e.Mismatches {
+		if m.Missing != nil || m.Extra != nil {
+			s = append(s, fmt.Sprintf("%s missing ports %v extra ports %v",
+				m.Oid, m.Missing, m.Extra))
+		} else {
+			s = append(s, fmt.Sprintf("%s want %d got %d", m.Oid, m.Want, m.Got))
+		}
+	}
+	return "verify failed: " + strings.Join(s, "; ")
+
+}
+
+// verifying returns whether read-back verification is enabled.
+func (c *SwitchControllerSnmp) verifying() bool {
+
+	return c.options().Verify
+
+}
+
+// verify reads back the objects written by the provided pdus and compares
+// them with the values that were written.
+func (c *SwitchControllerSnmp) verify(pdus []gosnmp.SnmpPDU) error {
+
+	want := make(map[string]gosnmp.SnmpPDU)
+	var oids []string
+	for _, pdu := range pdus {
+		if _, ok := want[pdu.Name]; !ok {
+			oids = append(oids, pdu.Name)
+		}
+		// later writes to the same object win
+		want[pdu.Name] = pdu
+	}
+
+	limit := c.maxOids()
+
+	verr := &VerifyError{}
+	for len(oids) > 0 {
+		n := len(oids)
+		if n > limit {
+			n = limit
+		}
+		resp, err := c.client().Get(oids[:n])
+		if err != nil {
+			return fmt.Errorf("verify: %v", err)
+		}
+		for _, v := range resp.Variables {
+			w, ok := want[v.Name]
+			if !ok {
+				w, ok = want["."+v.Name]
+			}
+			if !ok {
+				continue
+			}
+			m, ok := compare(w, v)
+			if !ok {
+				verr.Mismatches = append(verr.Mismatches, m)
+			}
+		}
+		oids = oids[n:]
+	}
+
+	if len(verr.Mismatches) > 0 {
+		return verr
+	}
+	return nil
+
+}
+
+// compare checks the value read back, got, against the value written, want.
+func compare(want, got gosnmp.SnmpPDU) (Mismatch, bool) {
+
+	m := Mismatch{Oid: want.Name}
+
+	switch want.Type {
+	case gosnmp.OctetString:
+		w := want.Value.([]byte)
+		g, _ := got.Value.([]byte)
+		for i := 0; i < len(w)*8 || i < len(g)*8; i++ {
+			ws := IsPortSet(i, w)
+			gs := IsPortSet(i, g)
+			if ws && !gs {
+				m.Missing = append(m.Missing, i+1)
+			}
+			if gs && !ws {
+				m.Extra = append(m.Extra, i+1)
+			}
+		}
+		return m, m.Missing == nil && m.Extra == nil
+
+	default:
+		m.Want = pduInt(want)
+		m.Got = pduInt(got)
+		return m, m.Want == m.Got && got.Type != gosnmp.NoSuchInstance &&
+			got.Type != gosnmp.NoSuchObject
+	}
+
+}
+
+// pduInt returns the value of an integer valued pdu.
+func pduInt(v gosnmp.SnmpPDU) int {
+
+	switch x := v.Value.(type) {
+	case int:
+		return x
+	case uint:
+		return int(x)
+	case uint32:
+		return int(x)
+	case uint64:
+		return int(x)
+	}
+	return -1
+
+}
@@ -0,0 +1,131 @@
+package snmp
+
+import (
+	"github.com/deter-project/switch-drivers/snmp/internal/snmptest"
+	"github.com/soniah/gosnmp"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A normalizing agent accepts every SET but stores something else, as some
+// agents do. It drops port 1 from the portlists written and stores every
+// pvid as 1.
+type normalizing struct {
+	*snmptest.Recording
+}
+
+func (a *normalizing) Set(pdus []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error) {
+
+	stored := make([]gosnmp.SnmpPDU, len(pdus))
+	for i, pdu := range pdus {
+		switch v := pdu.Value.(type) {
+		case []byte:
+			list := append([]byte(nil), v...)
+			UnsetPort(0, list)
+			pdu.Value = list
+		case uint:
+			pdu.Value = uint(1)
+		}
+		stored[i] = pdu
+	}
+	_, err := a.Recording.Set(stored)
+	if err != nil {
+		return nil, err
+	}
+	return &gosnmp.SnmpPacket{Variables: pdus}, nil
+
+}
+
+func TestVerifyMismatch(t *testing.T) {
+
+	rec, err := snmptest.LoadRecording(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewSwitchControllerAgent(&normalizing{rec}, &Options{Verify: true})
+
+	err = c.MovePortAccess([]int{1}, 10)
+	verr, ok := err.(*VerifyError)
+	if !ok {
+		t.Fatalf("got %v, want a *VerifyError", err)
+	}
+
+	// vlan 1 loses port 1 as intended, vlan 10 and the pvid do not change
+	want := map[string]Mismatch{
+		vlanEgressOid(10): {Oid: vlanEgressOid(10), Missing: []int{1}},
+		vlanAccessOid(10): {Oid: vlanAccessOid(10), Missing: []int{1}},
+		pvidOid(1):        {Oid: pvidOid(1), Want: 10, Got: 1},
+	}
+	if len(verr.Mismatches) != len(want) {
+		t.Errorf("got %d mismatches, want %d: %v",
+			len(verr.Mismatches), len(want), verr)
+	}
+	for _, m := range verr.Mismatches {
+		if !reflect.DeepEqual(m, want[m.Oid]) {
+			t.Errorf("got %+v, want %+v", m, want[m.Oid])
+		}
+	}
+	if !strings.Contains(verr.Error(), pvidOid(1)) {
+		t.Errorf("error %q does not name %s", verr.Error(), pvidOid(1))
+	}
+
+}
+
+func TestVerifyMatch(t *testing.T) {
+
+	c, _ := loadFixture(t, &Options{Verify: true})
+	err := c.MovePortAccess([]int{1}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func TestCompare(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		want, got gosnmp.SnmpPDU
+		mismatch  Mismatch
+		ok        bool
+	}{
+		{"same portlist",
+			gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte{0xc0}},
+			gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte{0xc0}},
+			Mismatch{}, true},
+		// an agent may send a list shorter or longer than the one written
+		{"short portlist",
+			gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte{0x80, 0x00}},
+			gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte{0x80}},
+			Mismatch{}, true},
+		{"ports differ",
+			gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte{0x80}},
+			gosnmp.SnmpPDU{Type: gosnmp.OctetString, Value: []byte{0x40, 0x01}},
+			Mismatch{Missing: []int{1}, Extra: []int{2, 16}}, false},
+		{"same integer",
+			gosnmp.SnmpPDU{Type: gosnmp.Gauge32, Value: uint(10)},
+			gosnmp.SnmpPDU{Type: gosnmp.Gauge32, Value: uint(10)},
+			Mismatch{Want: 10, Got: 10}, true},
+		{"integer types",
+			gosnmp.SnmpPDU{Type: gosnmp.Integer, Value: 10},
+			gosnmp.SnmpPDU{Type: gosnmp.Gauge32, Value: uint32(10)},
+			Mismatch{Want: 10, Got: 10}, true},
+		{"integers differ",
+			gosnmp.SnmpPDU{Type: gosnmp.Gauge32, Value: uint(10)},
+			gosnmp.SnmpPDU{Type: gosnmp.Gauge32, Value: uint64(20)},
+			Mismatch{Want: 10, Got: 20}, false},
+		{"no such instance",
+			gosnmp.SnmpPDU{Type: gosnmp.Gauge32, Value: uint(10)},
+			gosnmp.SnmpPDU{Type: gosnmp.NoSuchInstance},
+			Mismatch{Want: 10, Got: -1}, false},
+	}
+	for _, x := range tests {
+		m, ok := compare(x.want, x.got)
+		if ok != x.ok || !reflect.DeepEqual(m, x.mismatch) {
+			t.Errorf("%s: got %+v %v, want %+v %v",
+				x.name, m, ok, x.mismatch, x.ok)
+		}
+	}
+
+}